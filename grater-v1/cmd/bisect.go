@@ -0,0 +1,297 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/spf13/cobra"
+	"grater-basics/internal"
+)
+
+// BisectStep is the outcome of testing the dependent against one commit.
+type BisectStep struct {
+	Commit  string `json:"commit"`
+	Subject string `json:"subject"`
+	Result  string `json:"result"` // good, bad or skip
+	Error   string `json:"error,omitempty"`
+	Log     string `json:"log,omitempty"`
+}
+
+// BisectResult is what grater bisect saves for a module.
+type BisectResult struct {
+	Module string `json:"module"`
+	Repo   string `json:"repo"`
+	Good   string `json:"good"`
+	Bad    string `json:"bad"`
+
+	FirstBad        string `json:"first_bad"`
+	FirstBadSubject string `json:"first_bad_subject"`
+	FirstBadAuthor  string `json:"first_bad_author"`
+
+	// Candidates is set when untestable commits sit right before FirstBad,
+	// so any of them could be the first bad commit.
+	Candidates []string `json:"candidates,omitempty"`
+
+	Steps []BisectStep `json:"steps"`
+}
+
+var bisectCmd = &cobra.Command{
+	Use:   "bisect <module>",
+	Short: "Find the upstream commit that broke a module",
+	Long: `Find the first upstream commit between base and head that makes a module fail.
+
+The module must be a REGRESSION in the last grater run. Its base and head
+commits and the dependent's commit are taken from .grater/detailed_results.json,
+and the dependent is tested against commits in between, head only, until the
+first bad one is found. Commits that can't be tested (e.g. infrastructure
+errors) are skipped. Merged branches are treated as their merge commit.
+
+The result is saved to .grater/bisect/<module>/bisect.json.
+
+Examples:
+  grater bisect github.com/foo/bar
+  grater bisect github.com/foo/bar --runner local`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		module := args[0]
+		if err := validateCPUs(cpuLimit); err != nil {
+			return fmt.Errorf("invalid --cpus: %w", err)
+		}
+		if err := setupMirrors(); err != nil {
+			return err
+		}
+
+		projectRoot, err := os.Getwd()
+		if err != nil {
+			return err
+		}
+		graterDir := filepath.Join(projectRoot, ".grater")
+
+		last, err := lastRunResult(filepath.Join(graterDir, "detailed_results.json"), module)
+		if err != nil {
+			return err
+		}
+		if status := classify(last); status != "REGRESSION" {
+			return fmt.Errorf("%s is %s in the last run, only regressions can be bisected", module, status)
+		}
+		if last.Base.Commit == "" || last.Head.Commit == "" {
+			return fmt.Errorf("the last run didn't record base and head commits for %s (local checkouts and module versions can't be bisected)", module)
+		}
+		// Credentials in the repo URL are redacted from results
+		if strings.Contains(last.Repo, "***@") {
+			return fmt.Errorf("the last run's repo URL held credentials, which aren't saved; use a .netrc or credential helper instead")
+		}
+		repo = last.Repo
+
+		upstream, err := internal.PrepareUpstream(graterDir, repo, gitMirror)
+		if err != nil {
+			return err
+		}
+		// The clone only has the commits earlier runs fetched
+		for _, sha := range []string{last.Base.Commit, last.Head.Commit} {
+			if _, err := upstream.Resolve(sha); err != nil {
+				return err
+			}
+		}
+		commits, err := upstream.Commits(last.Base.Commit, last.Head.Commit)
+		if err != nil {
+			return err
+		}
+		if len(commits) == 0 {
+			return fmt.Errorf("no commits between %s and %s", last.Base.Commit, last.Head.Commit)
+		}
+
+		bisectDir := filepath.Join(graterDir, "bisect", module)
+		runLogDir = filepath.Join(bisectDir, "logs")
+		runID := "bisect-" + time.Now().Format("20060102-150405")
+		runner, err := newRunner(graterDir, runID)
+		if err != nil {
+			return err
+		}
+
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+
+		result := BisectResult{
+			Module: module,
+			Repo:   repo,
+			Good:   last.Base.Commit,
+			Bad:    last.Head.Commit,
+		}
+		fmt.Printf("🔎 Bisecting %s across %d commits of %s\n", module, len(commits), internal.Redact(repo))
+
+		cloneURL := resolveCloneURL(os.Stdout, module)
+
+		// test checks out commit and runs the dependent's head side against it
+		test := func(sha string) (BisectStep, error) {
+			step := BisectStep{Commit: sha}
+			step.Subject, _, _ = upstream.CommitInfo(sha)
+			dir, err := upstream.Checkout(sha)
+			if err != nil {
+				return step, err
+			}
+			job := internal.Job{
+				Module:       module,
+				Repo:         repo,
+				Base:         last.Base.Commit,
+				Head:         sha,
+				HeadDir:      dir,
+				Only:         "head",
+				ModuleCommit: last.ModuleCommit,
+				CloneURL:     cloneURL,
+				CloneSSH:     cloneSSH,
+				GitMirror:    gitMirror,
+				LogDir:       filepath.Join(runLogDir, sha),
+				CPUs:         cpuLimit,
+				Memory:       memLimit,
+			}
+			// Keep the tool output in the logs; bisect only prints the verdicts
+			r, err := runner.Run(ctx, job, io.Discard)
+			if err != nil {
+				return step, err
+			}
+			step.Log = r.Head.Log
+			step.Error = r.Head.Error
+			switch {
+			case r.Error != "":
+				step.Result, step.Error = "skip", r.Error
+			case r.Head.Passed:
+				step.Result = "good"
+			case r.Head.RealFailure():
+				step.Result = "bad"
+			default:
+				step.Result = "skip"
+			}
+			return step, nil
+		}
+
+		// Invariant: lo is good (or base, -1) and hi is bad. Skipped commits
+		// are dropped from the search.
+		lo, hi := -1, len(commits)-1
+		skipped := make(map[int]bool)
+		for {
+			var untested []int
+			for i := lo + 1; i < hi; i++ {
+				if !skipped[i] {
+					untested = append(untested, i)
+				}
+			}
+			if len(untested) == 0 {
+				break
+			}
+			mid := untested[len(untested)/2]
+
+			step, err := test(commits[mid])
+			if err != nil {
+				if ctx.Err() != nil {
+					cmd.SilenceUsage = true
+					return fmt.Errorf("interrupted")
+				}
+				return err
+			}
+			result.Steps = append(result.Steps, step)
+			fmt.Printf("   %s %s %s\n", verdictSymbol(step.Result), step.Commit[:12], step.Subject)
+
+			switch step.Result {
+			case "good":
+				lo = mid
+			case "bad":
+				hi = mid
+			default:
+				skipped[mid] = true
+			}
+		}
+
+		result.FirstBad = commits[hi]
+		result.FirstBadSubject, result.FirstBadAuthor, err = upstream.CommitInfo(commits[hi])
+		if err != nil {
+			return err
+		}
+		for i := lo + 1; i < hi; i++ {
+			result.Candidates = append(result.Candidates, commits[i])
+		}
+		if len(result.Candidates) > 0 {
+			result.Candidates = append(result.Candidates, commits[hi])
+		}
+
+		fmt.Println("\n========================================")
+		fmt.Printf("🎯 First bad commit: %s\n", result.FirstBad)
+		fmt.Printf("   Subject: %s\n", result.FirstBadSubject)
+		fmt.Printf("   Author:  %s\n", result.FirstBadAuthor)
+		if len(result.Candidates) > 0 {
+			fmt.Printf("   ⚠️  %d commits before it couldn't be tested; any of these could be the first bad one:\n", len(result.Candidates)-1)
+			for _, c := range result.Candidates {
+				fmt.Printf("      %s\n", c)
+			}
+		}
+		fmt.Println("========================================")
+
+		out, err := json.MarshalIndent(result, "", "  ")
+		if err != nil {
+			return fmt.Errorf("failed to marshal bisect result: %w", err)
+		}
+		resultFile := filepath.Join(bisectDir, "bisect.json")
+		if err := os.MkdirAll(bisectDir, 0755); err != nil {
+			return err
+		}
+		if err := os.WriteFile(resultFile, out, 0644); err != nil {
+			return fmt.Errorf("failed to write bisect result: %w", err)
+		}
+		fmt.Printf("💾 Bisect result saved to %s\n", resultFile)
+		return nil
+	},
+}
+
+// lastRunResult returns the detailed result of module from the last run.
+func lastRunResult(detailedFile, module string) (internal.DualResult, error) {
+	data, err := os.ReadFile(detailedFile)
+	if err != nil {
+		return internal.DualResult{}, fmt.Errorf("detailed_results.json not found. Run 'grater run' first: %w", err)
+	}
+	var detailed []internal.DualResult
+	if err := json.Unmarshal(data, &detailed); err != nil {
+		return internal.DualResult{}, fmt.Errorf("failed to parse detailed_results.json: %w", err)
+	}
+	for _, d := range detailed {
+		if d.Module == module {
+			return d, nil
+		}
+	}
+	return internal.DualResult{}, fmt.Errorf("module %s not found in %s", module, detailedFile)
+}
+
+func verdictSymbol(result string) string {
+	switch result {
+	case "good":
+		return "✅"
+	case "bad":
+		return "❌"
+	}
+	return "⏭️ "
+}
+
+func init() {
+	bisectCmd.Flags().StringVar(&runnerKind, "runner", "docker", "Runner backend: docker or local")
+	bisectCmd.Flags().StringVar(&image, "image", "grater-runner", "Runner image: the repository builds are tagged in, or with --no-build or --pull the image to use as is")
+	bisectCmd.Flags().BoolVar(&noBuild, "no-build", false, "Use --image as is instead of building the runner image")
+	bisectCmd.Flags().BoolVar(&pullImage, "pull", false, "Pull --image from its registry and use it instead of building the runner image")
+	bisectCmd.Flags().BoolVar(&noCache, "no-cache", false, "Don't share Go module and build caches between commits")
+	bisectCmd.Flags().BoolVar(&isolateNet, "isolate-network", false, "Clone and download with network, then build and test in a container without network")
+	bisectCmd.Flags().StringSliceVar(&credKinds, "credentials", nil, "Git credentials to forward into containers for private repos: netrc, ssh-agent, credential-cache")
+	bisectCmd.Flags().BoolVar(&cloneSSH, "ssh", false, "Clone the dependent over SSH (git@host:path.git) instead of anonymous HTTPS")
+	bisectCmd.Flags().BoolVar(&offline, "offline", false, "Run without network: clone from --git-mirror and download modules from --goproxy only")
+	bisectCmd.Flags().StringVar(&goproxy, "goproxy", "", "Module proxy for jobs, e.g. file:///mirror filled by grater mirror sync")
+	bisectCmd.Flags().StringVar(&gitMirror, "git-mirror", "", "Directory of bare git mirrors (<dir>/<host>/<path>.git) to clone from instead of the remotes")
+	bisectCmd.Flags().StringVar(&cpuLimit, "cpus", "", "CPUs available to each container, e.g. 2 or 1.5 (default: no limit)")
+	bisectCmd.Flags().StringVar(&memLimit, "memory", "", "Memory available to each container, e.g. 4g (default: no limit)")
+
+	rootCmd.AddCommand(bisectCmd)
+}
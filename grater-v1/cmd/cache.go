@@ -0,0 +1,127 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/spf13/cobra"
+	"grater-basics/internal"
+)
+
+var (
+	pruneMaxSize string
+	pruneAll     bool
+)
+
+// checkoutMaxAge is how long an upstream checkout is kept after its last use.
+const checkoutMaxAge = 7 * 24 * time.Hour
+
+var cacheCmd = &cobra.Command{
+	Use:   "cache",
+	Short: "Manage the shared Go caches used by grater run",
+}
+
+var cachePruneCmd = &cobra.Command{
+	Use:   "prune",
+	Short: "Shrink the shared Go module and build caches",
+	Long: `Shrink the Go caches under .grater/cache that grater run shares between modules.
+
+The build cache is trimmed by deleting its least recently used entries until it
+fits in --max-size. The module cache can't be trimmed safely, so it is cleared
+completely when it is larger than --max-size. Upstream checkouts that no run or
+bisect used for a week are removed.
+
+Examples:
+  grater cache prune                  # Keep each cache under 10GB
+  grater cache prune --max-size 2GB   # Keep each cache under 2GB
+  grater cache prune --all            # Delete both caches, cached base results and upstream checkouts`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		projectRoot, err := os.Getwd()
+		if err != nil {
+			return err
+		}
+
+		maxBytes, err := internal.ParseSize(pruneMaxSize)
+		if err != nil {
+			return err
+		}
+		if pruneAll {
+			maxBytes = 0
+		}
+
+		caches, err := internal.EnsureGoCaches(filepath.Join(projectRoot, ".grater"))
+		if err != nil {
+			return err
+		}
+
+		modSize, err := internal.DirSize(caches.ModCache)
+		if err != nil {
+			return fmt.Errorf("failed to measure module cache: %w", err)
+		}
+		if modSize > maxBytes {
+			if err := internal.RemoveModCache(caches.ModCache); err != nil {
+				return fmt.Errorf("failed to clear module cache: %w", cachePermissionHint(err))
+			}
+			fmt.Printf("🧹 Module cache cleared (%s freed)\n", internal.FormatSize(modSize))
+		} else {
+			fmt.Printf("✅ Module cache is %s, nothing to prune\n", internal.FormatSize(modSize))
+		}
+
+		freed, err := internal.TrimBuildCache(caches.BuildCache, maxBytes)
+		if err != nil {
+			return fmt.Errorf("failed to trim build cache: %w", cachePermissionHint(err))
+		}
+		if freed > 0 {
+			fmt.Printf("🧹 Build cache trimmed (%s freed)\n", internal.FormatSize(freed))
+		} else {
+			fmt.Println("✅ Build cache is within limits, nothing to prune")
+		}
+
+		// Checkouts in use by a concurrent run or bisect were used recently,
+		// so only those unused for a week go, unless --all asks for every one
+		unusedFor := checkoutMaxAge
+		if pruneAll {
+			unusedFor = 0
+		}
+		removed, err := internal.PruneCheckouts(filepath.Join(projectRoot, ".grater"), unusedFor)
+		if err != nil {
+			return fmt.Errorf("failed to remove upstream checkouts: %w", err)
+		}
+		if removed > 0 {
+			fmt.Printf("🧹 Upstream checkouts removed (%d)\n", removed)
+		} else {
+			fmt.Println("✅ No unused upstream checkouts to remove")
+		}
+
+		// Cached base results are tiny; they are only dropped on request
+		if pruneAll {
+			if err := os.RemoveAll(filepath.Join(projectRoot, ".grater", "cache", "base")); err != nil {
+				return fmt.Errorf("failed to clear base results: %w", err)
+			}
+			fmt.Println("🧹 Cached base results cleared")
+		}
+
+		return nil
+	},
+}
+
+// cachePermissionHint explains permission errors, which come from files
+// written by runner containers that ran as root before they ran as the user.
+func cachePermissionHint(err error) error {
+	if errors.Is(err, fs.ErrPermission) {
+		return fmt.Errorf("%w (files written by root containers of an older grater; remove .grater/cache with sudo once)", err)
+	}
+	return err
+}
+
+func init() {
+	cachePruneCmd.Flags().StringVar(&pruneMaxSize, "max-size", "10GB", "Maximum size of each cache")
+	cachePruneCmd.Flags().BoolVar(&pruneAll, "all", false, "Delete both caches, cached base results and upstream checkouts completely")
+
+	cacheCmd.AddCommand(cachePruneCmd)
+	rootCmd.AddCommand(cacheCmd)
+}
@@ -0,0 +1,11 @@
+package main
+
+import (
+	"os"
+
+	"grater-basics/internal"
+)
+
+func main() {
+	os.Exit(internal.RunAgent(os.Stdout, os.Stderr))
+}
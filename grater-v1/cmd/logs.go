@@ -0,0 +1,97 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/spf13/cobra"
+	"grater-basics/internal"
+)
+
+var logsRef string
+
+var logsCmd = &cobra.Command{
+	Use:   "logs <module>",
+	Short: "Print the full logs of a module from the last run",
+	Long: `Print the full base and head logs that grater run saved for a module.
+
+Logs are kept per run under .grater/runs/<run-id>/logs/<module>/ and the paths
+of the last run are recorded in .grater/detailed_results.json.
+
+Examples:
+  grater logs github.com/foo/bar              # Print base and head logs
+  grater logs github.com/foo/bar --ref head   # Print only the head log
+  grater logs github.com/foo/bar --ref main   # Print the main log of a --ref run`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		module := args[0]
+		projectRoot, err := os.Getwd()
+		if err != nil {
+			return err
+		}
+		d, err := lastRunResult(filepath.Join(projectRoot, ".grater", "detailed_results.json"), module)
+		if err != nil {
+			return err
+		}
+
+		// Multi-ref runs have a log per ref, selected by ref name
+		if len(d.Refs) > 0 {
+			found := false
+			for i, r := range d.Refs {
+				if logsRef == "" || logsRef == r.Ref {
+					found = true
+					if err := printLog(fmt.Sprintf("ref %d", i+1), r); err != nil {
+						return err
+					}
+				}
+			}
+			if !found {
+				return fmt.Errorf("ref %q not found in the last run of %s", logsRef, module)
+			}
+			return nil
+		}
+
+		if logsRef != "" && logsRef != "base" && logsRef != "head" {
+			return fmt.Errorf("invalid --ref %q: must be base or head", logsRef)
+		}
+		if logsRef != "head" {
+			if err := printLog("base", d.Base); err != nil {
+				return err
+			}
+		}
+		if logsRef != "base" {
+			if err := printLog("head", d.Head); err != nil {
+				return err
+			}
+		}
+		return nil
+	},
+}
+
+// printLog copies the saved log of one ref to stdout under a header.
+func printLog(side string, ref internal.RefResult) error {
+	if ref.Log == "" {
+		fmt.Printf("ℹ️  No %s log recorded\n", side)
+		return nil
+	}
+
+	f, err := os.Open(ref.Log)
+	if err != nil {
+		return fmt.Errorf("failed to open %s log: %w", side, err)
+	}
+	defer f.Close()
+
+	fmt.Println("════════════════════════════════════════════════════════════════════════════════")
+	fmt.Printf("📜 %s (%s): %s\n", side, ref.Ref, ref.Log)
+	fmt.Println("════════════════════════════════════════════════════════════════════════════════")
+	_, err = io.Copy(os.Stdout, f)
+	return err
+}
+
+func init() {
+	logsCmd.Flags().StringVar(&logsRef, "ref", "", "Only print the log of this ref (base or head, or a ref name of a multi-ref run)")
+
+	rootCmd.AddCommand(logsCmd)
+}
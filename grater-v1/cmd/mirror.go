@@ -0,0 +1,163 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/spf13/cobra"
+	"grater-basics/internal"
+)
+
+// mirrorVersions are the published upstream versions mirror sync fills the
+// modules of.
+var mirrorVersions []string
+
+var mirrorCmd = &cobra.Command{
+	Use:   "mirror",
+	Short: "Manage the local mirrors used by offline runs",
+}
+
+var mirrorSyncCmd = &cobra.Command{
+	Use:   "sync",
+	Short: "Fill the module proxy and git mirrors for the modules in modules.txt",
+	Long: `Fill the local stand-ins that grater run --offline uses instead of the network.
+
+Every module in modules.txt, and the --repo under test if given, is mirrored
+into --git-mirror as a bare repo at <dir>/<host>/<path>.git. The modules each
+of them needs at the tip of its default branch are downloaded into the module
+proxy at --goproxy, a file:// URL. Existing mirrors are updated, so run it
+again to pick up new commits while a network is available.
+
+An offline run needs the modules of the refs it tests. Pass each git ref of
+--repo that runs will use as base or head with --ref (default: its default
+branch), and each published version they will use with --version, so the
+modules the dependents need with it are downloaded too.
+
+Examples:
+  grater mirror sync --goproxy file:///mirror --git-mirror /mirrors
+  grater mirror sync --goproxy file:///mirror --git-mirror /mirrors --repo github.com/foo/bar --ref main --ref feature
+  grater mirror sync --goproxy file:///mirror --git-mirror /mirrors --repo github.com/foo/bar --version v1.19.0
+  grater run --offline --goproxy file:///mirror --git-mirror /mirrors --repo github.com/foo/bar`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		proxyDir := internal.FileProxyDir(goproxy)
+		if proxyDir == "" {
+			return fmt.Errorf("--goproxy must be a file:// URL, got %q", goproxy)
+		}
+		var err error
+		if proxyDir, err = filepath.Abs(proxyDir); err != nil {
+			return err
+		}
+		if gitMirror, err = filepath.Abs(gitMirror); err != nil {
+			return err
+		}
+		if repo == "" && len(refList) > 0 {
+			return fmt.Errorf("--ref needs --repo")
+		}
+		if repo == "" && upstreamModuleFlag == "" && len(mirrorVersions) > 0 {
+			return fmt.Errorf("--version needs --repo or --module")
+		}
+		for _, dir := range []string{proxyDir, gitMirror} {
+			if err := os.MkdirAll(dir, 0755); err != nil {
+				return fmt.Errorf("failed to create mirror dir: %w", err)
+			}
+		}
+
+		// Past the flags, failures are about repos and downloads, not usage
+		cmd.SilenceUsage = true
+
+		projectRoot, err := os.Getwd()
+		if err != nil {
+			return err
+		}
+		graterDir := filepath.Join(projectRoot, ".grater")
+		modules, _, err := loadModules(filepath.Join(graterDir, "modules.txt"))
+		if err != nil {
+			return err
+		}
+		env, err := internal.LoadGoEnv(graterDir)
+		if err != nil {
+			return fmt.Errorf("failed to load Go settings: %w", err)
+		}
+		index, err := internal.LoadMirrorIndex(gitMirror)
+		if err != nil {
+			return err
+		}
+
+		upstreamModule := upstreamModuleFlag
+		if upstreamModule == "" && len(mirrorVersions) > 0 {
+			version := mirrorVersions[0]
+			if version == internal.PinnedVersion && len(mirrorVersions) > 1 {
+				version = mirrorVersions[1]
+			}
+			if upstreamModule, err = internal.RepoRootModule(envProxy(env), repo, version); err != nil {
+				return fmt.Errorf("failed to find the module of %s at %s, pass it with --module: %w", internal.Redact(repo), version, err)
+			}
+			fmt.Printf("📦 Upstream module: %s\n", upstreamModule)
+		}
+
+		// Downloads go into a scratch module cache first, which already
+		// has the proxy layout
+		modCache, err := os.MkdirTemp("", "grater-modcache-")
+		if err != nil {
+			return err
+		}
+		defer func() {
+			internal.RemoveModCache(modCache)
+			os.RemoveAll(modCache)
+		}()
+
+		failed := 0
+		if repo != "" {
+			fmt.Printf("📦 Mirroring upstream repo %s\n", internal.Redact(repo))
+			if err := internal.MirrorModule(gitMirror, modCache, repo, "", refList, "", nil, env); err != nil {
+				fmt.Printf("❌ %v\n", err)
+				failed++
+			}
+		}
+		for i, m := range modules {
+			fmt.Printf("📦 [%d/%d] Mirroring %s\n", i+1, len(modules), m)
+			src, err := internal.ResolveModule(m)
+			if err != nil {
+				fmt.Printf("⚠️  %v\n", err)
+				src = internal.ModuleSource{Module: m, RepoURL: "https://" + m + ".git"}
+			}
+			if err := internal.MirrorModule(gitMirror, modCache, src.CloneURL(cloneSSH), m, nil, upstreamModule, mirrorVersions, env); err != nil {
+				fmt.Printf("❌ %v\n", err)
+				failed++
+				continue
+			}
+			index[m] = src.RepoURL
+		}
+
+		if err := internal.SaveMirrorIndex(gitMirror, index); err != nil {
+			return fmt.Errorf("failed to save mirror index: %w", err)
+		}
+		if err := internal.FillModuleProxy(proxyDir, modCache); err != nil {
+			return fmt.Errorf("failed to fill module proxy: %w", err)
+		}
+
+		fmt.Printf("\n✅ Git mirrors in %s\n", gitMirror)
+		fmt.Printf("✅ Module proxy in %s\n", proxyDir)
+		if failed > 0 {
+			return fmt.Errorf("%d repos failed to sync", failed)
+		}
+		return nil
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(mirrorCmd)
+	mirrorCmd.AddCommand(mirrorSyncCmd)
+
+	mirrorSyncCmd.Flags().StringVar(&goproxy, "goproxy", "", "Module proxy to fill, as a file:// URL")
+	mirrorSyncCmd.Flags().StringVar(&gitMirror, "git-mirror", "", "Directory of bare git mirrors to fill")
+	mirrorSyncCmd.Flags().StringVar(&repo, "repo", "", "Also mirror the repo under test")
+	mirrorSyncCmd.Flags().StringArrayVar(&refList, "ref", nil, "Git ref of --repo to fill the modules of; repeat for each base and head ref runs will use (default: its default branch)")
+	mirrorSyncCmd.Flags().StringArrayVar(&mirrorVersions, "version", nil, "Published version of the upstream module to fill the modules dependents need with; repeat for each --base-version and --head-version runs will use")
+	mirrorSyncCmd.Flags().StringVar(&upstreamModuleFlag, "module", "", "Path of the module at the root of --repo, used with --version (default: looked up in the module proxy)")
+	mirrorSyncCmd.Flags().BoolVar(&cloneSSH, "ssh", false, "Clone over SSH (git@host:path.git) instead of anonymous HTTPS")
+
+	mirrorSyncCmd.MarkFlagRequired("goproxy")
+	mirrorSyncCmd.MarkFlagRequired("git-mirror")
+}
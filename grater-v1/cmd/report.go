@@ -5,14 +5,17 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"slices"
+	"sort"
 
 	"github.com/spf13/cobra"
+	"grater-basics/internal"
 )
 
 // ModuleStatus is what results.json contains (written by run.go)
 type ModuleStatus struct {
 	Module string `json:"module"`
-	Status string `json:"status"` // PASS, BROKEN, REGRESSION, FIXED, SKIPPED, ERROR
+	Status string `json:"status"` // PASS, BROKEN, REGRESSION, FIXED, FLAKY, SKIPPED, ERROR
 }
 
 type ReportSummary struct {
@@ -23,9 +26,53 @@ type ReportSummary struct {
 	Regressions  []ModuleStatus `json:"regressions,omitempty"`
 	Fixed        []ModuleStatus `json:"fixed,omitempty"`
 	Broken       []ModuleStatus `json:"broken,omitempty"`
+	Flaky        []ModuleStatus `json:"flaky,omitempty"`
 	Skipped      []ModuleStatus `json:"skipped,omitempty"`
 	Passed       []ModuleStatus `json:"passed,omitempty"`
 	Errors       []ModuleStatus `json:"errors,omitempty"`
+	TestChanges  []TestChanges  `json:"test_changes,omitempty"`
+
+	// ByPhase groups every failed ref by the phase it failed in
+	ByPhase map[string][]PhaseFailure `json:"by_phase,omitempty"`
+
+	// Matrix is set when the run compared more than two refs
+	Matrix *RefMatrix `json:"matrix,omitempty"`
+
+	// Pinned maps each module to the upstream version it requires, for
+	// runs with --base pinned
+	Pinned map[string]string `json:"pinned,omitempty"`
+}
+
+// RefMatrix is the outcome of every module on every ref of a multi-ref run,
+// with base as the first column.
+type RefMatrix struct {
+	Refs []string    `json:"refs"`
+	Rows []MatrixRow `json:"rows"`
+}
+
+// MatrixRow is the outcome of one module on each ref. Transitions lists the
+// columns that pass where the column before failed, or the other way round.
+type MatrixRow struct {
+	Module      string   `json:"module"`
+	Cells       []string `json:"cells"` // pass, fail or skip
+	Transitions []int    `json:"transitions,omitempty"`
+}
+
+// PhaseFailure is one failed ref of a module, as listed in ByPhase.
+type PhaseFailure struct {
+	Module string `json:"module"`
+	Ref    string `json:"ref"` // base, head or the ref name in a multi-ref run
+	Kind   string `json:"kind,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+// TestChanges lists the individual tests of a module whose outcome differs
+// between base and head, as reported by `go test -json`.
+type TestChanges struct {
+	Module      string   `json:"module"`
+	NewFailures []string `json:"new_failures,omitempty"` // passed on base, failed on head
+	Fixed       []string `json:"fixed,omitempty"`        // failed on base, passed on head
+	Disappeared []string `json:"disappeared,omitempty"`  // ran on base, missing on head
 }
 
 var (
@@ -50,6 +97,7 @@ Examples:
 
 		graterDir := filepath.Join(projectRoot, ".grater")
 		resultsFile := filepath.Join(graterDir, "results.json")
+		detailedFile := filepath.Join(graterDir, "detailed_results.json")
 
 		data, err := os.ReadFile(resultsFile)
 		if err != nil {
@@ -61,13 +109,22 @@ Examples:
 			return fmt.Errorf("failed to parse results.json: %w", err)
 		}
 
+		// Per-test details are optional; older runs only have results.json
+		var detailed []internal.DualResult
+		if data, err := os.ReadFile(detailedFile); err == nil {
+			if err := json.Unmarshal(data, &detailed); err != nil {
+				return fmt.Errorf("failed to parse detailed_results.json: %w", err)
+			}
+		}
+
 		// base and head come from the run command flags
-		report := analyzeResults(results, base, head)
+		report := analyzeResults(results, detailed, base, head)
+		report.TestChanges = compareTests(detailed)
 		return outputReport(report)
 	},
 }
 
-func analyzeResults(results []ModuleStatus, baseRef, headRef string) ReportSummary {
+func analyzeResults(results []ModuleStatus, detailed []internal.DualResult, baseRef, headRef string) ReportSummary {
 	summary := ReportSummary{
 		TotalModules: len(results),
 		BaseRef:      baseRef,
@@ -75,6 +132,7 @@ func analyzeResults(results []ModuleStatus, baseRef, headRef string) ReportSumma
 		Regressions:  []ModuleStatus{},
 		Fixed:        []ModuleStatus{},
 		Broken:       []ModuleStatus{},
+		Flaky:        []ModuleStatus{},
 		Skipped:      []ModuleStatus{},
 		Passed:       []ModuleStatus{},
 		Errors:       []ModuleStatus{},
@@ -85,16 +143,35 @@ func analyzeResults(results []ModuleStatus, baseRef, headRef string) ReportSumma
 		return summary
 	}
 
+	byModule := make(map[string]internal.DualResult)
+	for _, d := range detailed {
+		byModule[d.Module] = d
+		if d.Base.Ref == internal.PinnedVersion && d.Base.Version != "" {
+			if summary.Pinned == nil {
+				summary.Pinned = make(map[string]string)
+			}
+			summary.Pinned[d.Module] = d.Base.Version
+		}
+	}
+
 	for _, r := range results {
 		switch r.Status {
 		case "PASS":
 			summary.Passed = append(summary.Passed, r)
 		case "REGRESSION":
+			// Only a compile or test failure on head is a real regression;
+			// anything else is infrastructure noise
+			if d, ok := byModule[r.Module]; ok && !d.Head.RealFailure() {
+				summary.Errors = append(summary.Errors, ModuleStatus{Module: r.Module, Status: "ERROR"})
+				continue
+			}
 			summary.Regressions = append(summary.Regressions, r)
 		case "FIXED":
 			summary.Fixed = append(summary.Fixed, r)
 		case "BROKEN":
 			summary.Broken = append(summary.Broken, r)
+		case "FLAKY":
+			summary.Flaky = append(summary.Flaky, r)
 		case "SKIPPED":
 			summary.Skipped = append(summary.Skipped, r)
 		case "ERROR":
@@ -102,9 +179,18 @@ func analyzeResults(results []ModuleStatus, baseRef, headRef string) ReportSumma
 		}
 	}
 
+	summary.ByPhase = groupByPhase(detailed)
+	summary.Matrix = buildMatrix(detailed)
+	// The refs the run actually compared, e.g. "pinned" or the ends of a
+	// multi-ref run, win over the run command's defaults
+	if len(detailed) > 0 {
+		summary.BaseRef = detailed[0].Base.Ref
+		summary.HeadRef = detailed[0].Head.Ref
+	}
+
 	if len(summary.Regressions) > 0 {
 		summary.Status = "UNSAFE"
-	} else if len(summary.Errors) > 0 || len(summary.Skipped) > 0 {
+	} else if len(summary.Errors) > 0 || len(summary.Skipped) > 0 || len(summary.Flaky) > 0 {
 		summary.Status = "INCONCLUSIVE"
 	} else if len(summary.Broken) > 0 && len(summary.Passed) == 0 && len(summary.Fixed) == 0 {
 		summary.Status = "INCONCLUSIVE"
@@ -115,6 +201,156 @@ func analyzeResults(results []ModuleStatus, baseRef, headRef string) ReportSumma
 	return summary
 }
 
+// groupByPhase lists every failed ref by the phase it failed in.
+func groupByPhase(detailed []internal.DualResult) map[string][]PhaseFailure {
+	byPhase := make(map[string][]PhaseFailure)
+	for _, d := range detailed {
+		type namedRef struct {
+			name   string
+			result internal.RefResult
+		}
+		refs := []namedRef{{"base", d.Base}, {"head", d.Head}}
+		if len(d.Refs) > 0 {
+			refs = nil
+			for _, r := range d.Refs {
+				refs = append(refs, namedRef{r.Ref, r})
+			}
+		}
+		for _, ref := range refs {
+			if ref.result.Passed {
+				continue
+			}
+			phase := ref.result.Phase
+			if phase == "" {
+				phase = "unknown"
+			}
+			byPhase[phase] = append(byPhase[phase], PhaseFailure{
+				Module: d.Module,
+				Ref:    ref.name,
+				Kind:   ref.result.Kind,
+				Error:  ref.result.Error,
+			})
+		}
+	}
+	if len(byPhase) == 0 {
+		return nil
+	}
+	return byPhase
+}
+
+// buildMatrix lays out the results of a multi-ref run as a module by ref
+// matrix. It returns nil for base..head runs.
+func buildMatrix(detailed []internal.DualResult) *RefMatrix {
+	var m *RefMatrix
+	for _, d := range detailed {
+		if len(d.Refs) == 0 {
+			continue
+		}
+		if m == nil {
+			m = &RefMatrix{Refs: refNames(d)}
+		}
+		if len(d.Refs) != len(m.Refs) {
+			continue
+		}
+		row := MatrixRow{Module: d.Module}
+		for i, r := range d.Refs {
+			cell := "fail"
+			switch {
+			case r.Skipped:
+				cell = "skip"
+			case r.Passed:
+				cell = "pass"
+			}
+			row.Cells = append(row.Cells, cell)
+			// Only flag changes between two refs that both ran
+			if i > 0 && cell != "skip" && row.Cells[i-1] != "skip" && cell != row.Cells[i-1] {
+				row.Transitions = append(row.Transitions, i)
+			}
+		}
+		m.Rows = append(m.Rows, row)
+	}
+	return m
+}
+
+// compareTests diffs the per-test outcomes of base and head for every module
+// and returns the modules where at least one test changed.
+func compareTests(detailed []internal.DualResult) []TestChanges {
+	var changes []TestChanges
+	for _, d := range detailed {
+		baseTests := make(map[string]string)
+		for _, t := range d.Base.Tests {
+			baseTests[testName(t)] = t.Action
+		}
+		headTests := make(map[string]string)
+		for _, t := range d.Head.Tests {
+			headTests[testName(t)] = t.Action
+		}
+
+		c := TestChanges{Module: d.Module}
+		for name, baseAction := range baseTests {
+			headAction, ok := headTests[name]
+			switch {
+			case !ok:
+				// A ref that never got to run tests says nothing about them
+				if len(d.Head.Tests) > 0 {
+					c.Disappeared = append(c.Disappeared, name)
+				}
+			case baseAction == "pass" && headAction == "fail":
+				c.NewFailures = append(c.NewFailures, name)
+			case baseAction == "fail" && headAction == "pass":
+				c.Fixed = append(c.Fixed, name)
+			}
+		}
+
+		if len(c.NewFailures) == 0 && len(c.Fixed) == 0 && len(c.Disappeared) == 0 {
+			continue
+		}
+		sort.Strings(c.NewFailures)
+		sort.Strings(c.Fixed)
+		sort.Strings(c.Disappeared)
+		changes = append(changes, c)
+	}
+	return changes
+}
+
+func testName(t internal.TestOutcome) string {
+	return t.Package + "." + t.Test
+}
+
+// phaseOrder returns the phases in byPhase in the order a ref runs through
+// them, with unknown phases last.
+func phaseOrder(byPhase map[string][]PhaseFailure) []string {
+	order := []string{
+		internal.PhaseClone, internal.PhaseFetch, internal.PhaseCheckout, internal.PhaseReplace,
+		internal.PhaseDownload, internal.PhaseBuild, internal.PhaseVet, internal.PhaseTest,
+	}
+	var phases []string
+	seen := make(map[string]bool)
+	for _, p := range order {
+		if _, ok := byPhase[p]; ok {
+			phases = append(phases, p)
+			seen[p] = true
+		}
+	}
+	var rest []string
+	for p := range byPhase {
+		if !seen[p] {
+			rest = append(rest, p)
+		}
+	}
+	sort.Strings(rest)
+	return append(phases, rest...)
+}
+
+// label is how module is listed in the report, with its pinned version if
+// base was pinned.
+func (s ReportSummary) label(module string) string {
+	if v, ok := s.Pinned[module]; ok {
+		return fmt.Sprintf("%s (pinned %s)", module, v)
+	}
+	return module
+}
+
 func outputReport(summary ReportSummary) error {
 	switch outputFormat {
 	case "json":
@@ -144,14 +380,14 @@ func outputHuman(summary ReportSummary) {
 	case "UNSAFE":
 		fmt.Println("❌ UNSAFE — regressions found!")
 	case "INCONCLUSIVE":
-		fmt.Println("⚠️  INCONCLUSIVE — some tests errored or were skipped")
+		fmt.Println("⚠️  INCONCLUSIVE — some tests errored, were skipped or are flaky")
 	}
 	fmt.Println()
 
 	if len(summary.Regressions) > 0 {
 		fmt.Printf("🔴 REGRESSIONS (%d) — base passed, head failed:\n", len(summary.Regressions))
 		for _, r := range summary.Regressions {
-			fmt.Printf("   • %s\n", r.Module)
+			fmt.Printf("   • %s\n", summary.label(r.Module))
 		}
 		fmt.Println()
 	}
@@ -159,7 +395,7 @@ func outputHuman(summary ReportSummary) {
 	if len(summary.Fixed) > 0 {
 		fmt.Printf("🟢 FIXED (%d) — base failed, head passed:\n", len(summary.Fixed))
 		for _, r := range summary.Fixed {
-			fmt.Printf("   • %s\n", r.Module)
+			fmt.Printf("   • %s\n", summary.label(r.Module))
 		}
 		fmt.Println()
 	}
@@ -167,7 +403,15 @@ func outputHuman(summary ReportSummary) {
 	if len(summary.Broken) > 0 {
 		fmt.Printf("🔧 BROKEN (%d) — both refs fail:\n", len(summary.Broken))
 		for _, r := range summary.Broken {
-			fmt.Printf("   • %s\n", r.Module)
+			fmt.Printf("   • %s\n", summary.label(r.Module))
+		}
+		fmt.Println()
+	}
+
+	if len(summary.Flaky) > 0 {
+		fmt.Printf("🎲 FLAKY (%d) — reruns of the failing ref disagreed:\n", len(summary.Flaky))
+		for _, r := range summary.Flaky {
+			fmt.Printf("   • %s\n", summary.label(r.Module))
 		}
 		fmt.Println()
 	}
@@ -175,15 +419,69 @@ func outputHuman(summary ReportSummary) {
 	if len(summary.Skipped) > 0 {
 		fmt.Printf("⏸️  SKIPPED (%d) — timed out:\n", len(summary.Skipped))
 		for _, r := range summary.Skipped {
-			fmt.Printf("   • %s\n", r.Module)
+			fmt.Printf("   • %s\n", summary.label(r.Module))
 		}
 		fmt.Println()
 	}
 
 	if len(summary.Errors) > 0 {
-		fmt.Printf("⚠️  ERRORS (%d) — container or execution failed:\n", len(summary.Errors))
+		fmt.Printf("⚠️  ERRORS (%d) — container, execution or infrastructure failed:\n", len(summary.Errors))
 		for _, r := range summary.Errors {
-			fmt.Printf("   • %s\n", r.Module)
+			fmt.Printf("   • %s\n", summary.label(r.Module))
+		}
+		fmt.Println()
+	}
+
+	if len(summary.TestChanges) > 0 {
+		fmt.Println("🧪 TEST CHANGES — individual tests that differ between base and head:")
+		for _, c := range summary.TestChanges {
+			fmt.Printf("   • %s\n", c.Module)
+			for _, t := range c.NewFailures {
+				fmt.Printf("       🔴 %s (new failure)\n", t)
+			}
+			for _, t := range c.Fixed {
+				fmt.Printf("       🟢 %s (fixed)\n", t)
+			}
+			for _, t := range c.Disappeared {
+				fmt.Printf("       ❔ %s (disappeared)\n", t)
+			}
+		}
+		fmt.Println()
+	}
+
+	if summary.Matrix != nil {
+		fmt.Println("🧮 REF MATRIX — 📉/📈 marks a module that broke/was fixed since the ref before:")
+		for i, ref := range summary.Matrix.Refs {
+			fmt.Printf("   [%d] %s\n", i+1, ref)
+		}
+		for _, row := range summary.Matrix.Rows {
+			fmt.Printf("   • %s\n      ", row.Module)
+			for i, cell := range row.Cells {
+				symbol := map[string]string{"pass": "✅", "fail": "❌", "skip": "⏰"}[cell]
+				fmt.Printf(" [%d] %s", i+1, symbol)
+				if slices.Contains(row.Transitions, i) {
+					if cell == "fail" {
+						fmt.Print(" 📉")
+					} else {
+						fmt.Print(" 📈")
+					}
+				}
+			}
+			fmt.Println()
+		}
+		fmt.Println()
+	}
+
+	if len(summary.ByPhase) > 0 {
+		fmt.Println("📍 FAILURES BY PHASE:")
+		for _, phase := range phaseOrder(summary.ByPhase) {
+			fmt.Printf("   %s (%d):\n", phase, len(summary.ByPhase[phase]))
+			for _, f := range summary.ByPhase[phase] {
+				fmt.Printf("       • %s [%s, %s]\n", f.Module, f.Ref, f.Kind)
+				if verbose && f.Error != "" {
+					fmt.Printf("         %s\n", f.Error)
+				}
+			}
 		}
 		fmt.Println()
 	}
@@ -191,17 +489,18 @@ func outputHuman(summary ReportSummary) {
 	if verbose && len(summary.Passed) > 0 {
 		fmt.Printf("✅ PASSING (%d):\n", len(summary.Passed))
 		for _, r := range summary.Passed {
-			fmt.Printf("   • %s\n", r.Module)
+			fmt.Printf("   • %s\n", summary.label(r.Module))
 		}
 		fmt.Println()
 	}
 
 	fmt.Println("════════════════════════════════════════════════════════════════════════════════")
-	fmt.Printf("✅ %d passed  🔴 %d regressions  🟢 %d fixed  🔧 %d broken  ⏸️  %d skipped  ⚠️  %d errors\n",
+	fmt.Printf("✅ %d passed  🔴 %d regressions  🟢 %d fixed  🔧 %d broken  🎲 %d flaky  ⏸️  %d skipped  ⚠️  %d errors\n",
 		len(summary.Passed),
 		len(summary.Regressions),
 		len(summary.Fixed),
 		len(summary.Broken),
+		len(summary.Flaky),
 		len(summary.Skipped),
 		len(summary.Errors),
 	)
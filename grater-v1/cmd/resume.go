@@ -0,0 +1,101 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+
+	"grater-basics/internal"
+)
+
+// loadPreviousRun reads the results of an earlier run so it can be resumed.
+// It refuses to resume a run made against a different repo, base or head.
+func loadPreviousRun(resultsFile, detailedFile string) (map[string]moduleOutcome, error) {
+	data, err := os.ReadFile(detailedFile)
+	if err != nil {
+		return nil, fmt.Errorf("nothing to resume, failed to read detailed_results.json: %w", err)
+	}
+	var detailed []internal.DualResult
+	if err := json.Unmarshal(data, &detailed); err != nil {
+		return nil, fmt.Errorf("failed to parse detailed_results.json: %w", err)
+	}
+
+	data, err = os.ReadFile(resultsFile)
+	if err != nil {
+		return nil, fmt.Errorf("nothing to resume, failed to read results.json: %w", err)
+	}
+	var results []ModuleStatus
+	if err := json.Unmarshal(data, &results); err != nil {
+		return nil, fmt.Errorf("failed to parse results.json: %w", err)
+	}
+	statuses := make(map[string]string)
+	for _, r := range results {
+		statuses[r.Module] = r.Status
+	}
+
+	previous := make(map[string]moduleOutcome)
+	for _, d := range detailed {
+		// Results only hold the repo with credentials redacted
+		if d.Repo != "" && d.Repo != internal.Redact(repo) {
+			return nil, fmt.Errorf("cannot resume: previous run used repo %s, not %s", d.Repo, internal.Redact(repo))
+		}
+		// Modules stopped by an interrupt never finished, so they run again
+		if d.Base.Error == "interrupted" || d.Head.Error == "interrupted" {
+			continue
+		}
+		if d.Base.Ref != base || d.Head.Ref != head {
+			return nil, fmt.Errorf("cannot resume: previous run compared %s..%s, not %s..%s",
+				d.Base.Ref, d.Head.Ref, base, head)
+		}
+		if !slices.Equal(refNames(d), refList) {
+			return nil, fmt.Errorf("cannot resume: previous run compared refs %v, not %v", refNames(d), refList)
+		}
+		// A ref that moved since the previous run would mix results of different commits
+		if d.Base.Commit != "" && baseCommit != "" && d.Base.Commit != baseCommit {
+			return nil, fmt.Errorf("cannot resume: %s was %s in the previous run but is now %s", base, d.Base.Commit, baseCommit)
+		}
+		if d.Head.Commit != "" && headCommit != "" && d.Head.Commit != headCommit {
+			return nil, fmt.Errorf("cannot resume: %s was %s in the previous run but is now %s", head, d.Head.Commit, headCommit)
+		}
+		status, ok := statuses[d.Module]
+		if !ok {
+			status = classify(d)
+		}
+		previous[d.Module] = moduleOutcome{
+			status:   ModuleStatus{Module: d.Module, Status: status},
+			detailed: d,
+		}
+	}
+	return previous, nil
+}
+
+// refNames lists the refs of a multi-ref result, or nil for base..head.
+func refNames(d internal.DualResult) []string {
+	var names []string
+	for _, r := range d.Refs {
+		names = append(names, r.Ref)
+	}
+	return names
+}
+
+// parseStatusList turns a comma separated --retry-status value into a set.
+func parseStatusList(list string) (map[string]bool, error) {
+	valid := map[string]bool{
+		"PASS": true, "BROKEN": true, "REGRESSION": true, "FIXED": true,
+		"FLAKY": true, "SKIPPED": true, "ERROR": true,
+	}
+	set := make(map[string]bool)
+	for _, s := range strings.Split(list, ",") {
+		s = strings.ToUpper(strings.TrimSpace(s))
+		if s == "" {
+			continue
+		}
+		if !valid[s] {
+			return nil, fmt.Errorf("unknown status %q in --retry-status", s)
+		}
+		set[s] = true
+	}
+	return set, nil
+}
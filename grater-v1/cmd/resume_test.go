@@ -0,0 +1,52 @@
+package cmd
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"grater-basics/internal"
+)
+
+func TestResumeInterruptedMultiRefRun(t *testing.T) {
+	repo, base, head, refList = "github.com/foo/bar", "v1", "x", []string{"v1", "main", "x"}
+	t.Cleanup(func() { repo, base, head, refList = "", "", "", nil })
+
+	job := internal.Job{Module: "example.com/a", Repo: repo, Base: base, Head: head, Refs: refList}
+	detailed := []internal.DualResult{
+		internal.SkippedResult(job, "", "interrupted"),
+		internal.SkippedResult(internal.Job{Module: "example.com/b", Repo: repo, Base: base, Head: head, Refs: refList}, internal.KindInfra, "boom"),
+	}
+	// Results written before interrupts recorded every ref
+	old := internal.DualResult{Module: "example.com/c", Repo: repo}
+	old.Base.Ref, old.Base.Error = base, "interrupted"
+	old.Head.Ref, old.Head.Error = head, "interrupted"
+	detailed = append(detailed, old)
+	results := []ModuleStatus{{Module: "example.com/a", Status: "SKIPPED"}, {Module: "example.com/b", Status: "ERROR"}}
+
+	dir := t.TempDir()
+	write := func(name string, v any) string {
+		data, err := json.Marshal(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, data, 0644); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	previous, err := loadPreviousRun(write("results.json", results), write("detailed_results.json", detailed))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, m := range []string{"example.com/a", "example.com/c"} {
+		if _, ok := previous[m]; ok {
+			t.Errorf("interrupted module %s is kept instead of run again", m)
+		}
+	}
+	if o, ok := previous["example.com/b"]; !ok || o.status.Status != "ERROR" {
+		t.Errorf("errored module = %+v, want it kept as ERROR", o)
+	}
+}
@@ -2,44 +2,131 @@ package cmd
 
 import (
 	"bytes"
+	"cmp"
+	"context"
 	"encoding/json"
 	"fmt"
+	"io"
 	"os"
 	"os/exec"
 	"os/signal"
 	"path/filepath"
+	"strconv"
 	"strings"
+	"sync"
 	"syscall"
+	"time"
 
 	"github.com/spf13/cobra"
+	graterbasics "grater-basics"
+	"grater-basics/internal"
 )
 
-// DualResult matches the detailed structure from runner.sh
-type DualResult struct {
-	Module string `json:"module"`
-	Base   struct {
-		Ref     string `json:"ref"`
-		Passed  bool   `json:"passed"`
-		Error   string `json:"error"`
-		Skipped bool   `json:"skipped"`
-	} `json:"base"`
-	Head struct {
-		Ref     string `json:"ref"`
-		Passed  bool   `json:"passed"`
-		Error   string `json:"error"`
-		Skipped bool   `json:"skipped"`
-	} `json:"head"`
-}
+var (
+	repo       string
+	base       string
+	head       string
+	image      string
+	noBuild    bool
+	pullImage  bool
+	jobs       int
+	retries    int
+	resume     bool
+	retryList  string
+	runnerKind string
+	baseDir    string
+	headDir    string
+	noCache    bool
+	cpuLimit   string
+	memLimit   string
+	isolateNet bool
+	refresh    bool
+	refList    []string
+	credKinds  []string
+	cloneSSH   bool
 
+	// Local stand-ins for the network: a file:// module proxy and a
+	// directory of bare git mirrors, filled by grater mirror sync
+	offline   bool
+	goproxy   string
+	gitMirror string
 
-var (
-	repo  string
-	base  string
-	head  string
-	image string
+	// Module to repo URL index of the git mirrors, set by setupMirrors
+	mirrorIndex map[string]string
+
+	// Published versions of the upstream module used as base and head
+	// instead of git refs
+	baseVersion string
+	headVersion string
+
+	// Path of the module at the root of --repo, from --module or set by
+	// runCmd for version refs so containers don't clone the repo to read it
+	upstreamModuleFlag string
+	upstreamModule     string
+
+	// Host directories and commits used as base and head, set by runCmd
+	baseSrc    string
+	headSrc    string
+	baseCommit string
+	headCommit string
+
+	// Host directories and commits of every --ref, set by runCmd
+	refSrcs    []string
+	refCommits []string
+
+	// Directory that holds this run's per-module logs, set by runCmd
+	runLogDir string
+
+	// Per-module resource limits from modules.txt, set by runCmd
+	moduleLimits map[string]resourceLimits
+
+	// Cache of base results and the toolchain and settings part of their
+	// keys, set by runCmd when docker tests base at an upstream commit
+	baseCache   *internal.BaseCache
+	goVersion   string
+	imageDigest string
+	envHash     string
 )
 
-func writeResults(resultsFile, detailedFile string, allResults []ModuleStatus, detailedResults []DualResult) error {
+// resourceLimits overrides --cpus and --memory for one module.
+type resourceLimits struct {
+	cpus   string
+	memory string
+}
+
+// moduleOutcome is the final result recorded for one module of a run.
+type moduleOutcome struct {
+	status   ModuleStatus
+	detailed internal.DualResult
+}
+
+// runState collects outcomes from concurrent workers. Outcomes are stored by
+// module index so results are always written in modules.txt order.
+type runState struct {
+	mu       sync.Mutex
+	outcomes []*moduleOutcome
+	done     int
+}
+
+func (s *runState) record(i int, o moduleOutcome) {
+	s.outcomes[i] = &o
+	s.done++
+}
+
+func (s *runState) collect() ([]ModuleStatus, []internal.DualResult) {
+	var allResults []ModuleStatus
+	var detailedResults []internal.DualResult
+	for _, o := range s.outcomes {
+		if o == nil {
+			continue
+		}
+		allResults = append(allResults, o.status)
+		detailedResults = append(detailedResults, o.detailed)
+	}
+	return allResults, detailedResults
+}
+
+func writeResults(resultsFile, detailedFile string, allResults []ModuleStatus, detailedResults []internal.DualResult) error {
 	simpleOut, err := json.MarshalIndent(allResults, "", "  ")
 	if err != nil {
 		return fmt.Errorf("failed to marshal results: %w", err)
@@ -71,6 +158,7 @@ func printSummary(allResults []ModuleStatus) {
 			"FIXED":      "🔧",
 			"SKIPPED":    "⏰",
 			"ERROR":      "❌",
+			"FLAKY":      "🎲",
 		}[result.Status]
 		fmt.Printf("%s %s: %s\n", symbol, result.Module, result.Status)
 	}
@@ -82,6 +170,58 @@ var runCmd = &cobra.Command{
 	Short: "Run downstream tests on base and head and detect regressions",
 	RunE: func(cmd *cobra.Command, args []string) error {
 
+		if jobs < 1 {
+			return fmt.Errorf("--jobs must be at least 1, got %d", jobs)
+		}
+		if retries < 0 {
+			return fmt.Errorf("--retries must not be negative, got %d", retries)
+		}
+		if err := validateCPUs(cpuLimit); err != nil {
+			return fmt.Errorf("invalid --cpus: %w", err)
+		}
+		if err := setupMirrors(); err != nil {
+			return err
+		}
+		// With --ref, base and head are the first and last ref
+		if len(refList) > 0 {
+			if len(refList) < 2 {
+				return fmt.Errorf("--ref must be given at least twice, got %d", len(refList))
+			}
+			for _, flag := range []string{"base", "head", "base-dir", "head-dir"} {
+				if cmd.Flags().Changed(flag) {
+					return fmt.Errorf("--ref can't be combined with --%s", flag)
+				}
+			}
+			base, head = refList[0], refList[len(refList)-1]
+		}
+		// A published version replaces the git ref of its side
+		for _, v := range []struct {
+			flag, ref, dir string
+			version        string
+			label          *string
+		}{
+			{"base-version", "base", "base-dir", baseVersion, &base},
+			{"head-version", "head", "head-dir", headVersion, &head},
+		} {
+			if v.version == "" {
+				continue
+			}
+			for _, flag := range []string{v.ref, v.dir, "ref"} {
+				if cmd.Flags().Changed(flag) {
+					return fmt.Errorf("--%s can't be combined with --%s", v.flag, flag)
+				}
+			}
+			*v.label = v.version
+		}
+		// --base pinned tests each dependent against the upstream version
+		// it requires today
+		if base == internal.PinnedVersion && len(refList) == 0 {
+			if baseDir != "" {
+				return fmt.Errorf("--base %s can't be combined with --base-dir", internal.PinnedVersion)
+			}
+			baseVersion = internal.PinnedVersion
+		}
+
 		projectRoot, err := os.Getwd()
 		if err != nil {
 			return err
@@ -96,128 +236,168 @@ var runCmd = &cobra.Command{
 			return fmt.Errorf("failed to create .grater directory: %w", err)
 		}
 
-		if _, err := os.Stat(modulesFile); os.IsNotExist(err) {
-			return fmt.Errorf("modules.txt not found. Run 'grater prepare' first: %w", err)
+		modules, limits, err := loadModules(modulesFile)
+		if err != nil {
+			return err
 		}
+		moduleLimits = limits
 
-		dockerfilePath := filepath.Join(projectRoot, "docker", "dockerfile")
-		dockerContext := filepath.Join(projectRoot, "docker")
+		// Snapshot local checkouts so edits made during the run don't leak in
+		if baseSrc, err = snapshotCheckout(graterDir, "base", baseDir); err != nil {
+			return err
+		}
+		if headSrc, err = snapshotCheckout(graterDir, "head", headDir); err != nil {
+			return err
+		}
+		if baseSrc != "" && !cmd.Flags().Changed("base") {
+			base = "local:" + baseDir
+		}
+		if headSrc != "" && !cmd.Flags().Changed("head") {
+			head = "local:" + headDir
+		}
 
-		if _, err := os.Stat(dockerfilePath); os.IsNotExist(err) {
-			return fmt.Errorf("dockerfile not found at %s", dockerfilePath)
+		// Prepare the upstream refs once so every module tests the same commits
+		if (baseSrc == "" && baseVersion == "") || (headSrc == "" && headVersion == "") {
+			if err := prepareUpstream(graterDir); err != nil {
+				return err
+			}
+		}
+		// Versions, including --base pinned, find the upstream module in each
+		// dependent's go.mod by its path, so read it once here
+		if baseVersion != "" || headVersion != "" {
+			if upstreamModule, err = resolveUpstreamModule(graterDir); err != nil {
+				return err
+			}
 		}
 
-		data, err := os.ReadFile(modulesFile)
+		retryStatus, err := parseStatusList(retryList)
 		if err != nil {
-			return fmt.Errorf("failed to read modules.txt: %w", err)
+			return err
+		}
+		if len(retryStatus) > 0 && !resume {
+			return fmt.Errorf("--retry-status can only be used with --resume")
 		}
 
-		rawModules := strings.Split(string(data), "\n")
-		var modules []string
-		for _, m := range rawModules {
-			if trimmed := strings.TrimSpace(m); trimmed != "" {
-				modules = append(modules, trimmed)
+		state := &runState{outcomes: make([]*moduleOutcome, len(modules))}
+
+		// With --resume, keep finished modules and only queue the rest
+		pending := make([]int, 0, len(modules))
+		if resume {
+			previous, err := loadPreviousRun(resultsFile, detailedFile)
+			if err != nil {
+				return err
+			}
+			for i, m := range modules {
+				if o, ok := previous[m]; ok && !retryStatus[o.status.Status] {
+					state.record(i, o)
+					continue
+				}
+				pending = append(pending, i)
+			}
+			fmt.Printf("⏯️  Resuming: %d modules already done, %d to run\n", state.done, len(pending))
+		} else {
+			for i := range modules {
+				pending = append(pending, i)
 			}
 		}
 
-		if len(modules) == 0 {
-			return fmt.Errorf("no modules found in modules.txt")
+		runID := time.Now().Format("20060102-150405")
+		runner, err := newRunner(graterDir, runID)
+		if err != nil {
+			return err
 		}
 
-		fmt.Println("Building docker image...")
-		build := exec.Command(
-			"docker", "build",
-			"-t", image,
-			"-f", dockerfilePath,
-			dockerContext,
-		)
-		build.Stdout = os.Stdout
-		build.Stderr = os.Stderr
-		if err := build.Run(); err != nil {
-			return fmt.Errorf("docker build failed: %w", err)
+		// Base results only depend on commits, the toolchain and the Go
+		// settings, so they can be reused across runs. The local runner uses
+		// the host's toolchain and environment, which can change at any time.
+		if baseCommit != "" && runnerKind == "docker" {
+			if err := setupBaseCache(graterDir, runner); err != nil {
+				fmt.Printf("⚠️  Base results won't be cached: %v\n", err)
+			}
 		}
 
-		var allResults []ModuleStatus
-		var detailedResults []DualResult
+		runLogDir = filepath.Join(graterDir, "runs", runID, "logs")
+		fmt.Printf("📝 Logs for run %s in %s\n", runID, runLogDir)
 
-		// Handle Ctrl+C: save whatever completed so far then exit
-		sigCh := make(chan os.Signal, 1)
-		signal.Notify(sigCh, os.Interrupt, syscall.SIGTERM)
+		// Ctrl+C cancels ctx, which stops the running modules and their
+		// containers. A second Ctrl+C exits right away.
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+		// finished is closed before stop runs, so a normal exit isn't
+		// reported as an interrupt
+		finished := make(chan struct{})
+		defer close(finished)
 		go func() {
-			<-sigCh
-			fmt.Println("\n\n⚠️  Interrupted — saving partial results...")
-			if err := writeResults(resultsFile, detailedFile, allResults, detailedResults); err != nil {
-				fmt.Printf("❌ Failed to save partial results: %v\n", err)
-			} else {
-				fmt.Printf("💾 Partial results saved to %s\n", graterDir)
-				printSummary(allResults)
+			select {
+			case <-ctx.Done():
+			case <-finished:
+				return
 			}
-			os.Exit(1)
+			stop()
+			fmt.Println("\n\n⚠️  Interrupted — stopping running modules...")
 		}()
 
-		for i, m := range modules {
-			fmt.Println("\n========================================")
-			fmt.Printf("Testing module [%d/%d]: %s\n", i+1, len(modules), m)
-			fmt.Println("========================================")
+		if jobs > 1 {
+			fmt.Printf("Running %d modules with %d parallel jobs\n", len(modules), jobs)
+		}
 
-			dualResult, err := runDualContainer(image, m, repo, base, head)
-			if err != nil {
-				fmt.Printf("❌ Container error: %v\n", err)
-				errorResult := DualResult{Module: m}
-				errorResult.Base.Ref = base
-				errorResult.Head.Ref = head
-				errorResult.Base.Error = err.Error()
-				errorResult.Head.Error = err.Error()
-				errorResult.Base.Skipped = true
-				errorResult.Head.Skipped = true
-				allResults = append(allResults, ModuleStatus{Module: m, Status: "ERROR"})
-				detailedResults = append(detailedResults, errorResult)
-			} else {
-				status := "PASS"
-				if dualResult.Base.Skipped || dualResult.Head.Skipped {
-					status = "SKIPPED"
-				} else if dualResult.Base.Passed && !dualResult.Head.Passed {
-					status = "REGRESSION"
-				} else if !dualResult.Base.Passed && dualResult.Head.Passed {
-					status = "FIXED"
-				} else if !dualResult.Base.Passed && !dualResult.Head.Passed {
-					status = "BROKEN"
-				}
+		work := make(chan int)
+		var wg sync.WaitGroup
+		for w := 0; w < jobs; w++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for i := range work {
+					// Buffer each module's output so parallel jobs don't
+					// interleave; a single job streams it as it goes
+					var out io.Writer = os.Stdout
+					var buf bytes.Buffer
+					if jobs > 1 {
+						out = &buf
+					}
+					outcome := testModule(ctx, out, runner, i, len(modules), modules[i])
 
-				fmt.Printf("\n📊 Results for %s:\n", m)
-				fmt.Printf("   Base (%s): ", dualResult.Base.Ref)
-				if dualResult.Base.Skipped {
-					fmt.Printf("⏰ SKIPPED - %s\n", dualResult.Base.Error)
-				} else if dualResult.Base.Passed {
-					fmt.Printf("✅ PASS\n")
-				} else {
-					fmt.Printf("❌ FAIL - %s\n", dualResult.Base.Error)
-				}
+					state.mu.Lock()
+					state.record(i, outcome)
+					os.Stdout.Write(buf.Bytes())
 
-				fmt.Printf("   Head (%s): ", dualResult.Head.Ref)
-				if dualResult.Head.Skipped {
-					fmt.Printf("⏰ SKIPPED - %s\n", dualResult.Head.Error)
-				} else if dualResult.Head.Passed {
-					fmt.Printf("✅ PASS\n")
-				} else {
-					fmt.Printf("❌ FAIL - %s\n", dualResult.Head.Error)
+					// Incremental save after every module regardless of pass/fail/error
+					allResults, detailedResults := state.collect()
+					if err := writeResults(resultsFile, detailedFile, allResults, detailedResults); err != nil {
+						fmt.Printf("⚠️  Failed to save progress: %v\n", err)
+					} else {
+						fmt.Printf("💾 Progress saved [%d/%d]\n", state.done, len(modules))
+					}
+					state.mu.Unlock()
 				}
-				fmt.Printf("   Status: %s\n", status)
+			}()
+		}
 
-				allResults = append(allResults, ModuleStatus{Module: m, Status: status})
-				detailedResults = append(detailedResults, dualResult)
+	feed:
+		for _, i := range pending {
+			select {
+			case work <- i:
+			case <-ctx.Done():
+				break feed
 			}
+		}
+		close(work)
+		wg.Wait()
 
-			// Incremental save after every module regardless of pass/fail/error
-			if err := writeResults(resultsFile, detailedFile, allResults, detailedResults); err != nil {
-				fmt.Printf("⚠️  Failed to save progress: %v\n", err)
-			} else {
-				fmt.Printf("💾 Progress saved [%d/%d]\n", i+1, len(modules))
+		allResults, _ := state.collect()
+
+		if ctx.Err() != nil {
+			if d, ok := runner.(internal.DockerRunner); ok {
+				if err := d.RemoveContainers(); err != nil {
+					fmt.Printf("⚠️  %v\n", err)
+				}
 			}
+			fmt.Printf("💾 Partial results saved to %s\n", graterDir)
+			printSummary(allResults)
+			cmd.SilenceUsage = true
+			return fmt.Errorf("interrupted, continue with --resume")
 		}
 
-		signal.Stop(sigCh)
-
 		fmt.Printf("\n✅ results.json saved to %s\n", resultsFile)
 		fmt.Printf("✅ detailed_results.json saved to %s\n", detailedFile)
 		printSummary(allResults)
@@ -226,62 +406,632 @@ var runCmd = &cobra.Command{
 	},
 }
 
-func runDualContainer(image, module, repo, baseRef, headRef string) (DualResult, error) {
-	cmd := exec.Command(
-		"docker", "run", "--rm",
-		"-e", "MODULE="+module,
-		"-e", "REPO="+repo,
-		"-e", "BASE_REF="+baseRef,
-		"-e", "HEAD_REF="+headRef,
-		image,
-	)
+// testModule runs a single module and writes its progress and results to out.
+func testModule(ctx context.Context, out io.Writer, runner internal.Runner, i, total int, m string) moduleOutcome {
+	fmt.Fprintln(out, "\n========================================")
+	fmt.Fprintf(out, "Testing module [%d/%d]: %s\n", i+1, total, m)
+	fmt.Fprintln(out, "========================================")
 
-	var stdout bytes.Buffer
-	var stderr bytes.Buffer
-	cmd.Stdout = &stdout
-	cmd.Stderr = &stderr
+	job := newJob(m)
+	job.CloneURL = resolveCloneURL(out, m)
 
-	runErr := cmd.Run()
+	// Pin the dependent so base and head, and cached results, test the
+	// same commit
+	commit, err := internal.ResolveModuleCommit(job)
+	if err != nil {
+		fmt.Fprintf(out, "⚠️  %v\n", err)
+	}
+	job.ModuleCommit = commit
 
-	// Stream container stderr so logs are visible in the terminal
-	if stderr.Len() > 0 {
-		fmt.Fprintf(os.Stderr, "%s", stderr.String())
+	key := internal.BaseKey{UpstreamCommit: baseCommit, ModuleCommit: commit, GoVersion: goVersion, ImageDigest: imageDigest, EnvHash: envHash}
+	var cachedBase *internal.RefResult
+	if baseCache != nil && key.Complete() && !refresh && len(refList) == 0 {
+		if res, ok := baseCache.Load(key); ok {
+			fmt.Fprintf(out, "♻️  Reusing cached base result for %s@%s\n", m, commit)
+			job.Only = "head"
+			cachedBase = &res
+		}
 	}
 
-	rawJSON := bytes.TrimSpace(stdout.Bytes())
+	dualResult, err := runner.Run(ctx, job, out)
+	if ctx.Err() != nil {
+		fmt.Fprintln(out, "⚠️  Interrupted")
+		interrupted := internal.SkippedResult(job, "", "interrupted")
+		return moduleOutcome{status: ModuleStatus{Module: m, Status: "SKIPPED"}, detailed: interrupted}
+	}
+	if err != nil {
+		fmt.Fprintf(out, "❌ Runner error: %s\n", internal.Redact(err.Error()))
+		errorResult := internal.SkippedResult(job, internal.KindInfra, err.Error())
+		errorResult.Error = errorResult.Head.Error
+		return moduleOutcome{status: ModuleStatus{Module: m, Status: "ERROR"}, detailed: errorResult}
+	}
 
-	if len(rawJSON) == 0 {
-		if runErr != nil {
-			return DualResult{}, fmt.Errorf("container exited with error and produced no JSON: %v", runErr)
+	for i := range dualResult.Refs {
+		if dualResult.Refs[i].Commit == "" {
+			dualResult.Refs[i].Commit = refCommits[i]
 		}
-		return DualResult{}, fmt.Errorf("container produced no JSON output (stdout was empty)")
+	}
+	syncRefEnds(&dualResult)
+	if dualResult.Base.Commit == "" {
+		dualResult.Base.Commit = baseCommit
+	}
+	if dualResult.Head.Commit == "" {
+		dualResult.Head.Commit = headCommit
 	}
 
-	var r DualResult
-	if err := json.Unmarshal(rawJSON, &r); err != nil {
-		return DualResult{}, fmt.Errorf("failed to parse container JSON: %v\nraw output: %s", err, string(rawJSON))
+	if cachedBase != nil {
+		dualResult.Base = *cachedBase
+		dualResult.Base.Ref = base
+		dualResult.Base.Cached = true
+	} else if baseCache != nil && key.Complete() && dualResult.Error == "" && len(refList) == 0 {
+		if err := baseCache.Store(key, dualResult.Base); err != nil {
+			fmt.Fprintf(out, "⚠️  %v\n", err)
+		}
 	}
 
-	if r.Module == "" {
-		r.Module = module
+	status := classify(dualResult)
+
+	// Reruns only make sense when base and head disagree
+	if retries > 0 && (status == "REGRESSION" || status == "FIXED") {
+		side := "head"
+		failing := &dualResult.Head
+		if status == "FIXED" {
+			side, failing = "base", &dualResult.Base
+		}
+		rerunFailingSide(ctx, out, runner, job, side, failing)
+		// Reruns are recorded on base and head; keep the ref list in step
+		if n := len(dualResult.Refs); n > 0 {
+			dualResult.Refs[0], dualResult.Refs[n-1] = dualResult.Base, dualResult.Head
+		}
+		status = classify(dualResult)
 	}
-	if r.Base.Ref == "" {
-		r.Base.Ref = baseRef
+
+	fmt.Fprintf(out, "\n📊 Results for %s:\n", m)
+	if len(dualResult.Refs) > 0 {
+		for i, r := range dualResult.Refs {
+			printRef(out, fmt.Sprintf("Ref %d", i+1), r)
+		}
+	} else {
+		printRef(out, "Base", dualResult.Base)
+		printRef(out, "Head", dualResult.Head)
 	}
-	if r.Head.Ref == "" {
-		r.Head.Ref = headRef
+	if dualResult.Error != "" {
+		fmt.Fprintf(out, "   Error: %s\n", dualResult.Error)
 	}
+	fmt.Fprintf(out, "   Status: %s\n", status)
 
-	return r, nil
+	return moduleOutcome{status: ModuleStatus{Module: m, Status: status}, detailed: dualResult}
+}
+
+// syncRefEnds makes base and head of a multi-ref result the first and
+// last ref.
+func syncRefEnds(d *internal.DualResult) {
+	if n := len(d.Refs); n > 0 {
+		d.Base, d.Head = d.Refs[0], d.Refs[n-1]
+	}
+}
+
+// resolveCloneURL finds the repo of module m, which for vanity paths like
+// go.uber.org/zap isn't https://<module>.git. It returns "" to fall back to
+// that URL when the module can't be resolved.
+func resolveCloneURL(out io.Writer, m string) string {
+	// Offline, the mirrors recorded the repo when they were synced
+	if offline {
+		return mirrorIndex[m]
+	}
+	src, err := internal.ResolveModule(m)
+	if err != nil {
+		fmt.Fprintf(out, "⚠️  %v\n", err)
+		return ""
+	}
+	return src.CloneURL(cloneSSH)
+}
+
+// newJob describes the work for module m from the run flags.
+func newJob(m string) internal.Job {
+	return internal.Job{
+		Module:      m,
+		Repo:        repo,
+		Base:        base,
+		Head:        head,
+		BaseDir:     baseSrc,
+		HeadDir:     headSrc,
+		BaseVersion: baseVersion,
+		HeadVersion: headVersion,
+		CloneSSH:    cloneSSH,
+		GitMirror:   gitMirror,
+		LogDir:      filepath.Join(runLogDir, m),
+		CPUs:        cmp.Or(moduleLimits[m].cpus, cpuLimit),
+		Memory:      cmp.Or(moduleLimits[m].memory, memLimit),
+		Refs:        refList,
+		RefDirs:     refSrcs,
+
+		UpstreamModule: upstreamModule,
+	}
+}
+
+// loadModules reads the modules to test, and their resource overrides,
+// from modules.txt.
+func loadModules(modulesFile string) ([]string, map[string]resourceLimits, error) {
+	if _, err := os.Stat(modulesFile); os.IsNotExist(err) {
+		return nil, nil, fmt.Errorf("modules.txt not found. Run 'grater prepare' first: %w", err)
+	}
+
+	data, err := os.ReadFile(modulesFile)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read modules.txt: %w", err)
+	}
+
+	var modules []string
+	limits := make(map[string]resourceLimits)
+	for _, line := range strings.Split(string(data), "\n") {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		m, l, err := parseModuleLine(line)
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid line in modules.txt: %w", err)
+		}
+		modules = append(modules, m)
+		limits[m] = l
+	}
+
+	if len(modules) == 0 {
+		return nil, nil, fmt.Errorf("no modules found in modules.txt")
+	}
+	return modules, limits, nil
+}
+
+// parseModuleLine parses a modules.txt line: a module path optionally
+// followed by resource overrides, e.g. "github.com/foo/bar cpus=4 memory=8g".
+func parseModuleLine(line string) (string, resourceLimits, error) {
+	fields := strings.Fields(line)
+	var limits resourceLimits
+	for _, f := range fields[1:] {
+		key, value, ok := strings.Cut(f, "=")
+		switch {
+		case !ok || value == "":
+			return "", limits, fmt.Errorf("%s: expected key=value, got %q", fields[0], f)
+		case key == "cpus":
+			if err := validateCPUs(value); err != nil {
+				return "", limits, fmt.Errorf("%s: %w", fields[0], err)
+			}
+			limits.cpus = value
+		case key == "memory":
+			limits.memory = value
+		default:
+			return "", limits, fmt.Errorf("%s: unknown setting %q (want cpus or memory)", fields[0], key)
+		}
+	}
+	return fields[0], limits, nil
+}
+
+func validateCPUs(cpus string) error {
+	if cpus == "" {
+		return nil
+	}
+	if n, err := strconv.ParseFloat(cpus, 64); err != nil || n <= 0 {
+		return fmt.Errorf("cpus must be a positive number, got %q", cpus)
+	}
+	return nil
+}
+
+// prepareUpstream mirrors --repo into the workspace, resolves base and head
+// to commits and checks them out for the refs that don't come from
+// --base-dir or --head-dir.
+func prepareUpstream(graterDir string) error {
+	upstream, err := internal.PrepareUpstream(graterDir, repo, gitMirror)
+	if err != nil {
+		return err
+	}
+
+	resolve := func(ref string) (string, string, error) {
+		sha, err := upstream.Resolve(ref)
+		if err != nil {
+			return "", "", err
+		}
+		dir, err := upstream.Checkout(sha)
+		if err != nil {
+			return "", "", err
+		}
+		fmt.Printf("📌 %s → %s\n", ref, sha)
+		return sha, dir, nil
+	}
+
+	if len(refList) > 0 {
+		refCommits = make([]string, len(refList))
+		refSrcs = make([]string, len(refList))
+		for i, ref := range refList {
+			if refCommits[i], refSrcs[i], err = resolve(ref); err != nil {
+				return err
+			}
+		}
+		baseCommit, baseSrc = refCommits[0], refSrcs[0]
+		headCommit, headSrc = refCommits[len(refList)-1], refSrcs[len(refList)-1]
+		return nil
+	}
+
+	if baseSrc == "" && baseVersion == "" {
+		if baseCommit, baseSrc, err = resolve(base); err != nil {
+			return err
+		}
+	}
+	if headSrc == "" && headVersion == "" {
+		if headCommit, headSrc, err = resolve(head); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// resolveUpstreamModule returns the path of the module at the root of
+// --repo: --module if given, or else read from a checkout the run already
+// has. When both refs are versions the run has no checkout, and the path is
+// read from the module proxy instead of cloning the repo.
+func resolveUpstreamModule(graterDir string) (string, error) {
+	if upstreamModuleFlag != "" {
+		return upstreamModuleFlag, nil
+	}
+	for _, dir := range []string{headSrc, baseSrc} {
+		if dir == "" {
+			continue
+		}
+		path, err := internal.ModulePath(dir)
+		if err != nil {
+			return "", fmt.Errorf("versions need a module at the root of %s (or --module): %w", internal.Redact(repo), err)
+		}
+		return path, nil
+	}
+
+	version := headVersion
+	if version == internal.PinnedVersion {
+		version = baseVersion
+	}
+	proxy := goproxy
+	if proxy == "" {
+		env, err := internal.LoadGoEnv(graterDir)
+		if err != nil {
+			return "", fmt.Errorf("failed to load Go settings: %w", err)
+		}
+		proxy = envProxy(env)
+	}
+	path, err := internal.RepoRootModule(proxy, repo, version)
+	if err != nil {
+		return "", fmt.Errorf("failed to find the module of %s at %s, pass it with --module: %w", internal.Redact(repo), version, err)
+	}
+	fmt.Printf("📦 Upstream module: %s\n", path)
+	return path, nil
+}
+
+// envProxy returns the GOPROXY set in env, or the default proxy.
+func envProxy(env []string) string {
+	proxy := ""
+	for _, kv := range env {
+		if value, ok := strings.CutPrefix(kv, "GOPROXY="); ok {
+			proxy = value
+		}
+	}
+	return cmp.Or(proxy, "https://proxy.golang.org")
+}
+
+// snapshotCheckout copies a local checkout given with --base-dir or
+// --head-dir into the workspace and returns the snapshot path.
+func snapshotCheckout(graterDir, side, dir string) (string, error) {
+	if dir == "" {
+		return "", nil
+	}
+	src, err := filepath.Abs(dir)
+	if err != nil {
+		return "", err
+	}
+	dst := filepath.Join(graterDir, "snapshots", side)
+	fmt.Printf("📸 Snapshotting %s checkout %s into %s\n", side, src, dst)
+	if err := internal.SnapshotWorkTree(src, dst); err != nil {
+		return "", fmt.Errorf("failed to snapshot %s: %w", dir, err)
+	}
+	return dst, nil
+}
+
+// rerunFailingSide reruns the failing ref up to --retries times and records
+// each attempt on it. It stops at the first passing attempt, since one pass
+// is enough to show the failure is not reproducible.
+func rerunFailingSide(ctx context.Context, out io.Writer, runner internal.Runner, job internal.Job, side string, failing *internal.RefResult) {
+	// Reruns only test one side, so they run as a plain base/head job
+	job.Refs, job.RefDirs = nil, nil
+	logDir := job.LogDir
+	for attempt := 1; attempt <= retries && ctx.Err() == nil; attempt++ {
+		fmt.Fprintf(out, "\n🔁 Rerunning %s (%s) [%d/%d]\n", side, failing.Ref, attempt, retries)
+		job.Only = side
+		job.LogDir = filepath.Join(logDir, fmt.Sprintf("rerun-%d", attempt))
+		r, err := runner.Run(ctx, job, out)
+		if ctx.Err() != nil {
+			return
+		}
+		if err != nil {
+			fmt.Fprintf(out, "❌ Runner error: %s\n", internal.Redact(err.Error()))
+			continue
+		}
+
+		rerun := r.Head
+		if side == "base" {
+			rerun = r.Base
+		}
+		// Drop per-test details; the original attempt already has them
+		rerun.Packages, rerun.Tests = nil, nil
+		failing.Reruns = append(failing.Reruns, rerun)
+		if rerun.Passed {
+			return
+		}
+	}
+}
+
+// classify derives a module's status from its base and head results.
+func classify(d internal.DualResult) string {
+	switch {
+	case d.Error != "":
+		return "ERROR"
+	case d.Base.Skipped || d.Head.Skipped:
+		return "SKIPPED"
+	case d.Base.Passed && !d.Head.Passed:
+		if !d.Head.RealFailure() {
+			return "ERROR"
+		}
+		if rerunPassed(d.Head) {
+			return "FLAKY"
+		}
+		return "REGRESSION"
+	case !d.Base.Passed && d.Head.Passed:
+		if !d.Base.RealFailure() {
+			return "ERROR"
+		}
+		if rerunPassed(d.Base) {
+			return "FLAKY"
+		}
+		return "FIXED"
+	case !d.Base.Passed && !d.Head.Passed:
+		if !d.Base.RealFailure() || !d.Head.RealFailure() {
+			return "ERROR"
+		}
+		return "BROKEN"
+	}
+	return "PASS"
+}
+
+func rerunPassed(r internal.RefResult) bool {
+	for _, rerun := range r.Reruns {
+		if rerun.Passed {
+			return true
+		}
+	}
+	return false
+}
+
+func printRef(out io.Writer, label string, r internal.RefResult) {
+	ref := r.Ref
+	if r.Cached {
+		ref += ", cached"
+	}
+	fmt.Fprintf(out, "   %s (%s): ", label, ref)
+	if r.Skipped {
+		fmt.Fprintf(out, "⏰ SKIPPED%s - %s\n", failureTag(r), r.Error)
+	} else if r.Passed {
+		fmt.Fprintf(out, "✅ PASS\n")
+	} else {
+		fmt.Fprintf(out, "❌ FAIL%s - %s\n", failureTag(r), r.Error)
+	}
+	if r.Version != "" {
+		fmt.Fprintf(out, "      version: %s\n", strings.TrimSpace(r.Version+" "+r.Sum))
+	}
+	if !r.Passed && r.Log != "" {
+		fmt.Fprintf(out, "      log: %s\n", r.Log)
+	}
+	for i, rerun := range r.Reruns {
+		result := "❌ FAIL"
+		if rerun.Skipped {
+			result = "⏰ SKIPPED"
+		} else if rerun.Passed {
+			result = "✅ PASS"
+		}
+		fmt.Fprintf(out, "      rerun %d: %s\n", i+1, result)
+	}
+}
+
+// failureTag renders the phase and kind of a failed ref, e.g. " [build/compile]".
+func failureTag(r internal.RefResult) string {
+	if r.Phase == "" && r.Kind == "" {
+		return ""
+	}
+	return fmt.Sprintf(" [%s/%s]", r.Phase, r.Kind)
+}
+
+// setupBaseCache opens the base result cache and reads the Go version, image
+// and Go settings the docker runner tests with, which are part of every
+// cache key.
+func setupBaseCache(graterDir string, runner internal.Runner) error {
+	c, err := internal.OpenBaseCache(graterDir)
+	if err != nil {
+		return err
+	}
+
+	r, ok := runner.(internal.DockerRunner)
+	if !ok {
+		return fmt.Errorf("only docker runs cache base results")
+	}
+	digest, err := exec.Command("docker", "image", "inspect", "--format", "{{.Id}}", r.Image).Output()
+	if err != nil {
+		return fmt.Errorf("failed to inspect image %s: %w", r.Image, err)
+	}
+	imageDigest = strings.TrimSpace(string(digest))
+	version, err := exec.Command("docker", "run", "--rm", "--entrypoint", "go", r.Image, "env", "GOVERSION").Output()
+	if err != nil {
+		return fmt.Errorf("failed to read Go version of %s: %w", r.Image, err)
+	}
+	envHash = internal.EnvHash(r.Env)
+	goVersion = strings.TrimSpace(string(version))
+	baseCache = &c
+	return nil
+}
+
+// setupMirrors checks --offline, --goproxy and --git-mirror and loads the
+// index of the git mirrors. An offline run needs both mirrors.
+func setupMirrors() error {
+	if goproxy != "" {
+		if dir := internal.FileProxyDir(goproxy); dir != "" {
+			abs, err := filepath.Abs(dir)
+			if err != nil {
+				return err
+			}
+			goproxy = "file://" + abs
+		}
+	}
+	if gitMirror != "" {
+		abs, err := filepath.Abs(gitMirror)
+		if err != nil {
+			return err
+		}
+		gitMirror = abs
+		if _, err := os.Stat(gitMirror); err != nil {
+			return fmt.Errorf("invalid --git-mirror: %w", err)
+		}
+	}
+	if !offline {
+		return nil
+	}
+
+	if internal.FileProxyDir(goproxy) == "" {
+		return fmt.Errorf("--offline needs --goproxy file:///<dir> to download modules from")
+	}
+	if gitMirror == "" {
+		return fmt.Errorf("--offline needs --git-mirror <dir> to clone repos from")
+	}
+	index, err := internal.LoadMirrorIndex(gitMirror)
+	if err != nil {
+		return err
+	}
+	mirrorIndex = index
+	return nil
+}
+
+// runnerImage returns the image of the docker runner. By default it is
+// built from the sources embedded in grater and tagged with their hash, so
+// it is only rebuilt when they change. With --no-build or --pull, --image is
+// used as is.
+func runnerImage() (string, error) {
+	if pullImage {
+		if offline {
+			return "", fmt.Errorf("--pull can't be combined with --offline")
+		}
+		if err := internal.PullImage(image); err != nil {
+			return "", err
+		}
+		return image, nil
+	}
+	if noBuild {
+		if !internal.ImageExists(image) {
+			return "", fmt.Errorf("image %s not found; use --pull to fetch it from its registry", image)
+		}
+		return image, nil
+	}
+
+	ref, err := internal.RunnerImageRef(image, graterbasics.RunnerSource)
+	if err != nil {
+		return "", err
+	}
+	if internal.ImageExists(ref) {
+		fmt.Printf("🐳 Using runner image %s\n", ref)
+		return ref, nil
+	}
+	// Building pulls the base image and Go modules, so an offline run
+	// uses the image built while online
+	if offline {
+		return "", fmt.Errorf("image %s not found; build it with a network before running offline, or use --no-build", ref)
+	}
+	fmt.Printf("🐳 Building runner image %s...\n", ref)
+	if err := internal.BuildRunnerImage(ref, graterbasics.RunnerSource); err != nil {
+		return "", err
+	}
+	return ref, nil
+}
+
+// newRunner builds the runner selected with --runner. The docker runner
+// builds the runner image first if needed.
+func newRunner(graterDir, runID string) (internal.Runner, error) {
+	var caches *internal.GoCaches
+	if !noCache {
+		c, err := internal.EnsureGoCaches(graterDir)
+		if err != nil {
+			return nil, err
+		}
+		caches = &c
+	}
+	env, err := internal.LoadGoEnv(graterDir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load Go settings: %w", err)
+	}
+	// Later settings win, so the mirrors override GOPROXY from the env file
+	if offline {
+		env = append(env, internal.OfflineEnv(goproxy)...)
+	} else if goproxy != "" {
+		env = append(env, "GOPROXY="+goproxy)
+	}
+
+	switch runnerKind {
+	case "docker":
+		image, err := runnerImage()
+		if err != nil {
+			return nil, err
+		}
+		creds, err := internal.HostCredentials(credKinds)
+		if err != nil {
+			return nil, err
+		}
+		passwd, err := internal.WritePasswd(graterDir)
+		if err != nil {
+			return nil, err
+		}
+		return internal.DockerRunner{Image: image, Caches: caches, IsolateNetwork: isolateNet, RunID: runID, Env: env, Credentials: creds, Offline: offline, Passwd: passwd}, nil
+	case "local":
+		if isolateNet {
+			return nil, fmt.Errorf("--isolate-network needs the docker runner")
+		}
+		// Local jobs already run with the host's git credentials
+		return internal.LocalRunner{Timeout: 300 * time.Second, Caches: caches, Env: env, NoNetwork: offline}, nil
+	default:
+		return nil, fmt.Errorf("unknown runner %q (expected docker or local)", runnerKind)
+	}
 }
 
 func init() {
 	rootCmd.AddCommand(runCmd)
 
 	runCmd.Flags().StringVar(&repo, "repo", "", "Repo under test")
-	runCmd.Flags().StringVar(&base, "base", "main", "Base git ref")
+	runCmd.Flags().StringVar(&base, "base", "main", "Base git ref, or \"pinned\" to test each dependent against the upstream version it requires")
 	runCmd.Flags().StringVar(&head, "head", "HEAD", "Head git ref")
-	runCmd.Flags().StringVar(&image, "image", "grater-runner", "Docker image name")
+	runCmd.Flags().StringVar(&baseVersion, "base-version", "", "Test the published version of the upstream module from the module proxy as base (e.g. v1.19.0) instead of a git ref")
+	runCmd.Flags().StringVar(&headVersion, "head-version", "", "Test the published version of the upstream module from the module proxy as head (e.g. v1.20.0-rc.1) instead of a git ref")
+	runCmd.Flags().StringArrayVar(&refList, "ref", nil, "Test against this ref; repeat to compare several refs, the first one being base (replaces --base and --head)")
+	runCmd.Flags().StringVar(&baseDir, "base-dir", "", "Test a local checkout (including uncommitted changes) as base instead of fetching --base")
+	runCmd.Flags().StringVar(&headDir, "head-dir", "", "Test a local checkout (including uncommitted changes) as head instead of fetching --head")
+	runCmd.Flags().StringVar(&image, "image", "grater-runner", "Runner image: the repository builds are tagged in, or with --no-build or --pull the image to use as is")
+	runCmd.Flags().BoolVar(&noBuild, "no-build", false, "Use --image as is instead of building the runner image")
+	runCmd.Flags().BoolVar(&pullImage, "pull", false, "Pull --image from its registry and use it instead of building the runner image")
+	runCmd.Flags().StringVar(&runnerKind, "runner", "docker", "Runner backend: docker or local")
+	runCmd.Flags().IntVarP(&jobs, "jobs", "j", 1, "Number of modules to test in parallel")
+	runCmd.Flags().IntVar(&retries, "retries", 0, "Rerun the failing ref up to N times when base and head disagree")
+
+	runCmd.Flags().StringVar(&cpuLimit, "cpus", "", "CPUs available to each module's container, e.g. 2 or 1.5 (default: no limit)")
+	runCmd.Flags().StringVar(&memLimit, "memory", "", "Memory available to each module's container, e.g. 4g (default: no limit)")
+	runCmd.Flags().BoolVar(&isolateNet, "isolate-network", false, "Clone and download with network, then build and test in a container without network")
+	runCmd.Flags().BoolVar(&refresh, "refresh-base", false, "Test base again instead of reusing cached base results")
+	runCmd.Flags().StringSliceVar(&credKinds, "credentials", nil, "Git credentials to forward into containers for private repos: netrc, ssh-agent, credential-cache")
+	runCmd.Flags().BoolVar(&cloneSSH, "ssh", false, "Clone dependents over SSH (git@host:path.git) instead of anonymous HTTPS")
+	runCmd.Flags().BoolVar(&offline, "offline", false, "Run without network: clone from --git-mirror and download modules from --goproxy only")
+	runCmd.Flags().StringVar(&upstreamModuleFlag, "module", "", "Path of the module at the root of --repo, used with --base-version, --head-version and --base pinned (default: read from its go.mod)")
+	runCmd.Flags().StringVar(&goproxy, "goproxy", "", "Module proxy for jobs, e.g. file:///mirror filled by grater mirror sync")
+	runCmd.Flags().StringVar(&gitMirror, "git-mirror", "", "Directory of bare git mirrors (<dir>/<host>/<path>.git) to clone from instead of the remotes")
+	runCmd.Flags().BoolVar(&noCache, "no-cache", false, "Don't share Go module and build caches between modules")
+	runCmd.Flags().BoolVar(&resume, "resume", false, "Resume the previous run, skipping modules that already finished")
+	runCmd.Flags().StringVar(&retryList, "retry-status", "", "With --resume, also rerun modules with these statuses (e.g. ERROR,SKIPPED)")
 
 	runCmd.MarkFlagRequired("repo")
-}
\ No newline at end of file
+}
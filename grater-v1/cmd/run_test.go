@@ -0,0 +1,40 @@
+package cmd
+
+import (
+	"testing"
+
+	"grater-basics/internal"
+)
+
+func TestClassify(t *testing.T) {
+	pass := internal.RefResult{Passed: true}
+	fail := func(kind string) internal.RefResult { return internal.RefResult{Kind: kind, Error: "failed"} }
+	flaky := fail(internal.KindTestFailure)
+	flaky.Reruns = []internal.RefResult{{Passed: true}}
+
+	for _, tc := range []struct {
+		name       string
+		base, head internal.RefResult
+		err        string
+		want       string
+	}{
+		{"both pass", pass, pass, "", "PASS"},
+		{"head test failure", pass, fail(internal.KindTestFailure), "", "REGRESSION"},
+		{"head compile error", pass, fail(internal.KindCompile), "", "REGRESSION"},
+		{"head panic", pass, fail(internal.KindPanic), "", "REGRESSION"},
+		{"head OOM", pass, fail(internal.KindOOM), "", "ERROR"},
+		{"head network", pass, fail(internal.KindNetwork), "", "ERROR"},
+		{"head passes on rerun", pass, flaky, "", "FLAKY"},
+		{"base fails", fail(internal.KindTestFailure), pass, "", "FIXED"},
+		{"base infra", fail(internal.KindInfra), pass, "", "ERROR"},
+		{"both fail", fail(internal.KindTestFailure), fail(internal.KindCompile), "", "BROKEN"},
+		{"both fail, one timeout", fail(internal.KindTestFailure), fail(internal.KindTimeout), "", "ERROR"},
+		{"skipped", pass, internal.RefResult{Skipped: true}, "", "SKIPPED"},
+		{"runner error", pass, pass, "boom", "ERROR"},
+	} {
+		d := internal.DualResult{Base: tc.base, Head: tc.head, Error: tc.err}
+		if got := classify(d); got != tc.want {
+			t.Errorf("%s: classify() = %q, want %q", tc.name, got, tc.want)
+		}
+	}
+}
@@ -2,10 +2,12 @@ module grater-basics
 
 go 1.25.1
 
-require github.com/spf13/cobra v1.10.2
+require (
+	github.com/PuerkitoBio/goquery v1.11.0
+	github.com/spf13/cobra v1.10.2
+)
 
 require (
-	github.com/PuerkitoBio/goquery v1.11.0 // indirect
 	github.com/andybalholm/cascadia v1.3.3 // indirect
 	github.com/inconshreveable/mousetrap v1.1.0 // indirect
 	github.com/spf13/pflag v1.0.9 // indirect
@@ -0,0 +1,216 @@
+package internal
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// RunAgent is the entrypoint of grater-agent, the binary that runs inside
+// the runner image. It reads the job from the environment set by
+// DockerRunner, runs it with a LocalRunner and writes the DualResult as JSON
+// to stdout. Progress and tool output go to stderr. It returns the process
+// exit code.
+func RunAgent(stdout, stderr io.Writer) int {
+	job := jobFromEnv(os.Getenv)
+
+	emit := func(r DualResult) {
+		enc := json.NewEncoder(stdout)
+		if err := enc.Encode(r); err != nil {
+			fmt.Fprintf(stderr, "❌ Failed to encode result: %v\n", err)
+		}
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if missing := job.missingEnv(); len(missing) > 0 {
+		fmt.Fprintf(stderr, "❌ Missing required env vars (%s)\n", strings.Join(missing, ", "))
+		emit(skipBoth(DualResult{Module: job.Module, Repo: job.Repo}, "missing env vars"))
+		return 1
+	}
+
+	runner := LocalRunner{Timeout: 300 * time.Second}
+	if secs, err := strconv.Atoi(os.Getenv("TIMEOUT")); err == nil && secs > 0 {
+		runner.Timeout = time.Duration(secs) * time.Second
+	}
+	// DockerRunner sets both when it mounts the shared caches
+	if os.Getenv("GOMODCACHE") != "" && os.Getenv("GOCACHE") != "" {
+		runner.Caches = &GoCaches{ModCache: os.Getenv("GOMODCACHE"), BuildCache: os.Getenv("GOCACHE")}
+	}
+	runner.Tags = setupGPU(stderr)
+	// DockerRunner sets these when it splits the job for network isolation
+	runner.WorkDir = os.Getenv("WORK_DIR")
+	runner.Stage = os.Getenv("STAGE")
+	runner.NoNetwork = os.Getenv("NO_NETWORK") == "1"
+	runner.MemoryLimit = job.Memory != ""
+
+	fmt.Fprintln(stderr, "")
+	fmt.Fprintln(stderr, "════════════════════════════════════════════════════════════════════════════════")
+	fmt.Fprintf(stderr, "🔬 TEST RUN: %s\n", job.Module)
+	fmt.Fprintf(stderr, "   Repo:     %s\n", job.Repo)
+	fmt.Fprintf(stderr, "   Base ref: %s\n", job.Base)
+	fmt.Fprintf(stderr, "   Head ref: %s\n", job.Head)
+	fmt.Fprintf(stderr, "   Timeout:  %s\n", runner.Timeout)
+	if runner.Stage != "" {
+		fmt.Fprintf(stderr, "   Stage:    %s\n", runner.Stage)
+	}
+	fmt.Fprintf(stderr, "   Started:  %s\n", time.Now().Format(time.RFC1123))
+	fmt.Fprintln(stderr, "════════════════════════════════════════════════════════════════════════════════")
+
+	r, err := runner.Run(ctx, job, stderr)
+	// Always emit a result so the host has something to parse, even when
+	// the container is stopped
+	if ctx.Err() != nil {
+		fmt.Fprintln(stderr, "\n⚠️  Interrupted — emitting results")
+		emit(skipBoth(DualResult{Module: job.Module, Repo: job.Repo}, "interrupted"))
+		return 1
+	}
+	if err != nil {
+		fmt.Fprintf(stderr, "❌ %v\n", err)
+		r = failBoth(r, "", KindInfra, err.Error())
+		r.Error = err.Error()
+	}
+
+	// Refs aren't built yet after the prepare stage, so there is nothing
+	// to summarize
+	if runner.Stage == StagePrepare {
+		fmt.Fprintln(stderr, "\n📦 Prepare stage done")
+	} else {
+		printAgentSummary(stderr, r)
+	}
+	emit(r)
+	return 0
+}
+
+// jobFromEnv reads the job DockerRunner passes in the environment.
+func jobFromEnv(getenv func(string) string) Job {
+	job := Job{
+		Module:  getenv("MODULE"),
+		Repo:    getenv("REPO"),
+		Base:    getenv("BASE_REF"),
+		Head:    getenv("HEAD_REF"),
+		BaseDir: getenv("BASE_DIR"),
+		HeadDir: getenv("HEAD_DIR"),
+		Only:    getenv("ONLY"),
+		LogDir:  getenv("LOG_DIR"),
+		CPUs:    getenv("CPUS"),
+		Memory:  getenv("MEMORY"),
+
+		ModuleCommit:   getenv("MODULE_COMMIT"),
+		BaseVersion:    getenv("BASE_VERSION"),
+		HeadVersion:    getenv("HEAD_VERSION"),
+		UpstreamModule: getenv("UPSTREAM_MODULE"),
+		CloneURL:       getenv("CLONE_URL"),
+		CloneSSH:       getenv("CLONE_SSH") == "1",
+		GitMirror:      getenv("GIT_MIRROR"),
+	}
+	if refs := getenv("REFS"); refs != "" {
+		job.Refs = strings.Split(refs, "\n")
+		job.RefDirs = strings.Split(getenv("REF_DIRS"), "\n")
+	}
+	return job
+}
+
+// missingEnv lists the env vars job needs but doesn't have. A job
+// restricted to one ref, like a bisect step, only needs that ref.
+func (j Job) missingEnv() []string {
+	var missing []string
+	if j.Module == "" {
+		missing = append(missing, "MODULE")
+	}
+	if j.Repo == "" {
+		missing = append(missing, "REPO")
+	}
+	if j.Base == "" && j.Only != "head" {
+		missing = append(missing, "BASE_REF")
+	}
+	if j.Head == "" && j.Only != "base" {
+		missing = append(missing, "HEAD_REF")
+	}
+	return missing
+}
+
+// setupGPU detects NVIDIA or AMD GPUs, exports the environment their
+// toolchains expect and returns the build tags to use.
+func setupGPU(stderr io.Writer) []string {
+	if _, err := exec.LookPath("nvidia-smi"); err == nil {
+		out, _ := exec.Command("nvidia-smi", "--query-gpu=name", "--format=csv,noheader").Output()
+		name, _, _ := strings.Cut(strings.TrimSpace(string(out)), "\n")
+		fmt.Fprintf(stderr, "✅ NVIDIA GPU detected: %s\n", name)
+		for key, value := range map[string]string{
+			"CUDA_VISIBLE_DEVICES": "all",
+			"TF_GPU_ALLOCATOR":     "cuda_malloc_async",
+			"TF_CPP_MIN_LOG_LEVEL": "1",
+			"CGO_CFLAGS":           "-I/usr/local/cuda/include",
+			"CGO_LDFLAGS":          "-L/usr/local/cuda/lib64 -lcuda -lcudart",
+			"CUDA_CACHE_MAXSIZE":   "2147483648",
+			"CUDA_CACHE_DISABLE":   "0",
+			"CUDA_LAUNCH_BLOCKING": "1",
+			"TF_GPU_THREAD_MODE":   "gpu_private",
+		} {
+			os.Setenv(key, value)
+		}
+		return []string{"cuda"}
+	}
+
+	if _, err := exec.LookPath("rocminfo"); err == nil {
+		fmt.Fprintln(stderr, "✅ AMD GPU detected - enabling ROCm support")
+		os.Setenv("ROCM_VISIBLE_DEVICES", "all")
+		os.Setenv("HIP_VISIBLE_DEVICES", "all")
+		os.Setenv("HCC_AMDGPU_TARGET", "gfx900,gfx906,gfx908,gfx90a")
+		return []string{"rocm"}
+	}
+
+	fmt.Fprintln(stderr, "ℹ️ No GPU detected - CPU-only mode")
+	return nil
+}
+
+func printAgentSummary(stderr io.Writer, r DualResult) {
+	fmt.Fprintln(stderr, "")
+	fmt.Fprintln(stderr, "════════════════════════════════════════════════════════════════════════════════")
+	fmt.Fprintf(stderr, "📊 FINAL RESULTS for %s\n", r.Module)
+
+	printRef := func(label string, ref RefResult) {
+		switch {
+		case ref.Skipped:
+			fmt.Fprintf(stderr, "   %s (%s): ⏰ SKIPPED - %s\n", label, ref.Ref, ref.Error)
+		case ref.Passed:
+			fmt.Fprintf(stderr, "   %s (%s): ✅ PASS\n", label, ref.Ref)
+		default:
+			fmt.Fprintf(stderr, "   %s (%s): ❌ FAIL - %s\n", label, ref.Ref, ref.Error)
+		}
+	}
+	if len(r.Refs) > 0 {
+		for i, ref := range r.Refs {
+			printRef(fmt.Sprintf("Ref %d", i+1), ref)
+		}
+	} else {
+		printRef("Base", r.Base)
+		printRef("Head", r.Head)
+	}
+
+	switch {
+	case r.Error != "":
+		fmt.Fprintf(stderr, "   Overall: ❌ ERROR - %s\n", r.Error)
+	case r.Base.Skipped || r.Head.Skipped:
+		fmt.Fprintln(stderr, "   Overall: ⏸️  INCOMPLETE")
+	case r.Base.Passed && r.Head.Passed:
+		fmt.Fprintln(stderr, "   Overall: ✅ PASS")
+	case r.Base.Passed:
+		fmt.Fprintln(stderr, "   Overall: ⚠️  REGRESSION")
+	case r.Head.Passed:
+		fmt.Fprintln(stderr, "   Overall: 🎉 FIXED")
+	default:
+		fmt.Fprintln(stderr, "   Overall: ❌ BROKEN")
+	}
+	fmt.Fprintln(stderr, "════════════════════════════════════════════════════════════════════════════════")
+}
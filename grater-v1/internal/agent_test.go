@@ -0,0 +1,179 @@
+package internal
+
+import (
+	"context"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// gitRepo commits files into a new repo in dir.
+func gitRepo(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, args := range [][]string{
+		{"init", "--quiet"},
+		{"add", "-A"},
+		{"-c", "user.name=test", "-c", "user.email=test@example.com", "commit", "--quiet", "-m", "init"},
+	} {
+		cmd := exec.Command("git", args...)
+		cmd.Dir = dir
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("git %s: %v\n%s", strings.Join(args, " "), err, out)
+		}
+	}
+}
+
+// agentJob passes job through the docker run arguments of DockerRunner and
+// reads it back the way grater-agent does. Mounted container paths are
+// mapped back to their host directories, so the job can run on the host.
+func agentJob(t *testing.T, job Job) Job {
+	t.Helper()
+	args, err := DockerRunner{Image: "grater-runner"}.runArgs(job, "test", "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	env := make(map[string]string)
+	mounts := make(map[string]string)
+	for i := 0; i+1 < len(args); i++ {
+		switch args[i] {
+		case "-e":
+			key, value, _ := strings.Cut(args[i+1], "=")
+			env[key] = value
+		case "-v":
+			parts := strings.Split(args[i+1], ":")
+			mounts[parts[1]] = parts[0]
+		}
+	}
+	for key, value := range env {
+		if host, ok := mounts[value]; ok {
+			env[key] = host
+		}
+	}
+	return jobFromEnv(func(key string) string { return env[key] })
+}
+
+func TestBisectStepThroughAgent(t *testing.T) {
+	for _, tool := range []string{"git", "go"} {
+		if _, err := exec.LookPath(tool); err != nil {
+			t.Skipf("%s not found", tool)
+		}
+	}
+
+	upstream := func(v string) string {
+		dir := t.TempDir()
+		gitRepo(t, dir, map[string]string{
+			"go.mod": "module example.com/up\n\ngo 1.21\n",
+			"up.go":  "package up\n\nfunc V() int { return " + v + " }\n",
+		})
+		return dir
+	}
+	dependent := t.TempDir()
+	gitRepo(t, dependent, map[string]string{
+		"go.mod":      "module example.com/dep\n\ngo 1.21\n\nrequire example.com/up v0.1.0\n",
+		"dep.go":      "package dep\n\nimport \"example.com/up\"\n\nfunc V() int { return up.V() }\n",
+		"dep_test.go": "package dep\n\nimport \"testing\"\n\nfunc TestV(t *testing.T) {\n\tif V() != 1 {\n\t\tt.Fatal(\"bad\")\n\t}\n}\n",
+	})
+
+	for _, tc := range []struct {
+		name   string
+		v      string
+		passed bool
+	}{
+		{"good", "1", true},
+		{"bad", "2", false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			// The job bisect builds for one step: head only, from a checkout
+			job := agentJob(t, Job{
+				Module:   "example.com/dep",
+				Repo:     "https://example.com/up.git",
+				Base:     "good",
+				Head:     "step",
+				HeadDir:  upstream(tc.v),
+				Only:     "head",
+				CloneURL: "file://" + dependent,
+			})
+			if missing := job.missingEnv(); len(missing) > 0 {
+				t.Fatalf("agent rejects the job: missing %v", missing)
+			}
+
+			runner := LocalRunner{Timeout: 2 * time.Minute, Env: []string{"GOPROXY=off", "GOFLAGS=-mod=mod"}}
+			r, err := runner.Run(context.Background(), job, io.Discard)
+			if err != nil {
+				t.Fatal(err)
+			}
+			switch {
+			case r.Error != "" || r.Head.Skipped:
+				t.Fatalf("step was skipped: %s%s", r.Error, r.Head.Error)
+			case r.Head.Passed != tc.passed:
+				t.Fatalf("head passed = %v, want %v: %s", r.Head.Passed, tc.passed, r.Head.Error)
+			case !tc.passed && !r.Head.RealFailure():
+				t.Fatalf("head failure isn't a real failure: %s %s", r.Head.Kind, r.Head.Error)
+			}
+		})
+	}
+}
+
+func TestMissingEnv(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		job  Job
+		want string
+	}{
+		{"both refs", Job{Module: "m", Repo: "r", Base: "b", Head: "h"}, ""},
+		{"head only", Job{Module: "m", Repo: "r", Head: "h", Only: "head"}, ""},
+		{"base only", Job{Module: "m", Repo: "r", Base: "b", Only: "base"}, ""},
+		{"no base", Job{Module: "m", Repo: "r", Head: "h"}, "BASE_REF"},
+		{"nothing", Job{}, "MODULE,REPO,BASE_REF,HEAD_REF"},
+	} {
+		if got := strings.Join(tc.job.missingEnv(), ","); got != tc.want {
+			t.Errorf("%s: missingEnv() = %q, want %q", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestAgentJobKeepsResourceLimits(t *testing.T) {
+	job := agentJob(t, Job{Module: "m", Repo: "r", Base: "b", Head: "h", CPUs: "1.5", Memory: "2g"})
+	if job.CPUs != "1.5" {
+		t.Errorf("CPUs = %q in the container, want 1.5", job.CPUs)
+	}
+	if job.Memory != "2g" {
+		t.Errorf("Memory = %q in the container, want 2g", job.Memory)
+	}
+}
+
+func TestPasswdFile(t *testing.T) {
+	want := "root:x:0:0:root:/root:/bin/sh\ngrater:x:1000:100:grater:/home/grater:/bin/sh\n"
+	if got := passwdFile(1000, 100, "/home/grater"); got != want {
+		t.Errorf("passwdFile = %q, want %q", got, want)
+	}
+	if got := passwdFile(0, 0, "/home/grater"); got != "root:x:0:0:root:/root:/bin/sh\n" {
+		t.Errorf("passwdFile for root = %q, want only root", got)
+	}
+
+	passwd, err := WritePasswd(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	args, err := DockerRunner{Image: "grater-runner", Passwd: passwd}.runArgs(Job{Module: "example.com/dep"}, "test", "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Contains(args, passwd+":/etc/passwd:ro") {
+		t.Errorf("passwd file isn't mounted read-only: %q", args)
+	}
+}
@@ -0,0 +1,99 @@
+package internal
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// BaseKey identifies a base result: the same dependent commit tested against
+// the same upstream commit with the same toolchain and Go settings gives the
+// same result.
+type BaseKey struct {
+	UpstreamCommit string `json:"upstream_commit"`
+	ModuleCommit   string `json:"module_commit"`
+	GoVersion      string `json:"go_version"`
+	ImageDigest    string `json:"image_digest"`
+	EnvHash        string `json:"env_hash"`
+}
+
+// EnvHash hashes the KEY=VALUE settings passed into jobs, e.g. GOFLAGS, for
+// a BaseKey. Only the hash is kept, as the values may hold credentials.
+func EnvHash(env []string) string {
+	sorted := slices.Clone(env)
+	slices.Sort(sorted)
+	sum := sha256.Sum256([]byte(strings.Join(sorted, "\n")))
+	return hex.EncodeToString(sum[:])
+}
+
+// BaseCache stores base results under ws/cache/base, one file per key named
+// after the key's hash.
+type BaseCache struct {
+	Dir string
+}
+
+type baseCacheEntry struct {
+	Key    BaseKey   `json:"key"`
+	Result RefResult `json:"result"`
+}
+
+// OpenBaseCache creates the base result cache under ws/cache/base.
+func OpenBaseCache(ws string) (BaseCache, error) {
+	dir := filepath.Join(ws, "cache", "base")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return BaseCache{}, fmt.Errorf("failed to create base cache: %w", err)
+	}
+	return BaseCache{Dir: dir}, nil
+}
+
+// Complete reports whether every part of k is known. Results can only be
+// cached under complete keys.
+func (k BaseKey) Complete() bool {
+	return k.UpstreamCommit != "" && k.ModuleCommit != "" && k.GoVersion != "" && k.ImageDigest != ""
+}
+
+func (c BaseCache) path(k BaseKey) string {
+	data, _ := json.Marshal(k)
+	sum := sha256.Sum256(data)
+	return filepath.Join(c.Dir, hex.EncodeToString(sum[:])+".json")
+}
+
+// Load returns the result cached under k, if any.
+func (c BaseCache) Load(k BaseKey) (RefResult, bool) {
+	data, err := os.ReadFile(c.path(k))
+	if err != nil {
+		return RefResult{}, false
+	}
+	var entry baseCacheEntry
+	// Entries written before only passes were cached may hold failures
+	if err := json.Unmarshal(data, &entry); err != nil || entry.Key != k || !entry.Result.Passed {
+		return RefResult{}, false
+	}
+	return entry.Result, true
+}
+
+// Store caches res under k if base passed. Failures are tested again next
+// time, since a flaky one cached for good would make head look fixed in
+// every later run.
+func (c BaseCache) Store(k BaseKey, res RefResult) error {
+	if !res.Passed {
+		return nil
+	}
+	res.Cached = false
+	res.Reruns = nil
+	data, err := json.MarshalIndent(baseCacheEntry{Key: k, Result: res}, "", "  ")
+	if err != nil {
+		return err
+	}
+	// Write then rename so parallel jobs never read a partial file
+	tmp := c.path(k) + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return fmt.Errorf("failed to cache base result: %w", err)
+	}
+	return os.Rename(tmp, c.path(k))
+}
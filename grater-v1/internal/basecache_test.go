@@ -0,0 +1,36 @@
+package internal
+
+import "testing"
+
+func TestBaseCacheStoresOnlyPasses(t *testing.T) {
+	c, err := OpenBaseCache(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	key := BaseKey{UpstreamCommit: "u", ModuleCommit: "m", GoVersion: "go1.25", ImageDigest: "sha256:x", EnvHash: EnvHash(nil)}
+
+	for _, kind := range []string{KindTestFailure, KindCompile, KindPanic} {
+		failed := RefResult{Ref: "main", Phase: PhaseTest, Kind: kind, Error: "failed"}
+		if !failed.RealFailure() {
+			t.Fatalf("%s isn't a real failure", kind)
+		}
+		if err := c.Store(key, failed); err != nil {
+			t.Fatal(err)
+		}
+		if _, ok := c.Load(key); ok {
+			t.Fatalf("failing base result (%s) was cached", kind)
+		}
+	}
+
+	if err := c.Store(key, RefResult{Ref: "main", Passed: true}); err != nil {
+		t.Fatal(err)
+	}
+	if res, ok := c.Load(key); !ok || !res.Passed {
+		t.Fatalf("passing base result wasn't cached: %+v, %v", res, ok)
+	}
+	other := key
+	other.EnvHash = EnvHash([]string{"GOFLAGS=-tags=foo"})
+	if _, ok := c.Load(other); ok {
+		t.Fatal("base result reused with different Go settings")
+	}
+}
@@ -0,0 +1,371 @@
+package internal
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+)
+
+// DockerRunner runs each job in a fresh container from Image, whose
+// entrypoint is grater-agent.
+type DockerRunner struct {
+	Image string
+
+	// Caches, when set, are mounted into every container so downloads and
+	// build results are shared between modules.
+	Caches *GoCaches
+
+	// IsolateNetwork splits each job into two containers sharing a work
+	// volume: the first clones and downloads dependencies, the second
+	// builds and tests with --network none.
+	IsolateNetwork bool
+
+	// RunID names and labels every container and volume of the run, so
+	// they can be found and removed when the run is interrupted.
+	RunID string
+
+	// Env holds KEY=VALUE settings, e.g. GOPRIVATE, set in every container.
+	Env []string
+
+	// Credentials are forwarded into the container that clones and
+	// downloads. Jobs that get them are always split like IsolateNetwork
+	// ones, so the dependent's build and tests never see them; without
+	// IsolateNetwork the second container keeps its network.
+	Credentials Credentials
+
+	// Offline runs every container with --network none. Clones and
+	// downloads are then served by the job's GitMirror and a file://
+	// GOPROXY in Env.
+	Offline bool
+
+	// Passwd is a passwd file written by WritePasswd, mounted read-only as
+	// /etc/passwd. Containers run as the host user, whom the image doesn't
+	// know, and ssh refuses to run for a user without an entry.
+	Passwd string
+}
+
+func (d DockerRunner) Run(ctx context.Context, job Job, logs io.Writer) (DualResult, error) {
+	// Credentials in URLs never reach the logs or the result
+	redacted := newRedactWriter(logs)
+	defer redacted.Flush()
+	r, err := d.run(ctx, job, redacted)
+	r.redact()
+	return r, err
+}
+
+func (d DockerRunner) run(ctx context.Context, job Job, logs io.Writer) (DualResult, error) {
+	var noNetwork []string
+	if d.Offline {
+		noNetwork = []string{"--network", "none", "-e", "NO_NETWORK=1"}
+	}
+	if !d.splitsJobs() {
+		return d.runContainer(ctx, job, logs, "", noNetwork...)
+	}
+
+	volume := d.containerName(job, "work")
+	create := exec.Command("docker", "volume", "create", "--label", runLabel+"="+d.RunID, volume)
+	if out, err := create.CombinedOutput(); err != nil {
+		return DualResult{}, fmt.Errorf("failed to create work volume: %v\n%s", err, out)
+	}
+	defer exec.Command("docker", "volume", "rm", "-f", volume).Run()
+	work := []string{"-v", volume + ":/work", "-e", "WORK_DIR=/work"}
+
+	r, err := d.runContainer(ctx, job, logs, StagePrepare, append(work, noNetwork...)...)
+	if err != nil || r.Error != "" {
+		return r, err
+	}
+	if !slices.ContainsFunc(job.refs(&r), func(ref jobRef) bool { return prepared(*ref.res) }) {
+		return r, nil
+	}
+	if d.IsolateNetwork {
+		noNetwork = []string{"--network", "none", "-e", "NO_NETWORK=1"}
+	}
+	return d.runContainer(ctx, job, logs, StageTest, append(work, noNetwork...)...)
+}
+
+// splitsJobs reports whether jobs run in a prepare and a test container.
+// Forwarded credentials must never reach the dependent's code, so jobs that
+// get them are split like isolated ones.
+func (d DockerRunner) splitsJobs() bool {
+	return d.IsolateNetwork || !d.Credentials.empty()
+}
+
+// containerHome is HOME inside runner containers, writable by any user.
+const containerHome = "/home/grater"
+
+// WritePasswd writes the passwd file of runner containers to dir/passwd and
+// returns its path. It has root and an entry for the host user, so the
+// image's /etc/passwd never needs to be writable by the user that runs the
+// dependent's code.
+func WritePasswd(dir string) (string, error) {
+	path := filepath.Join(dir, "passwd")
+	data := []byte(passwdFile(os.Getuid(), os.Getgid(), containerHome))
+	if old, err := os.ReadFile(path); err == nil && bytes.Equal(old, data) {
+		return path, nil
+	}
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		return "", fmt.Errorf("failed to write passwd file: %w", err)
+	}
+	return path, nil
+}
+
+// passwdFile is a passwd file with root and, unless uid is root, an entry
+// for uid with home as its home directory.
+func passwdFile(uid, gid int, home string) string {
+	passwd := "root:x:0:0:root:/root:/bin/sh\n"
+	if uid > 0 {
+		passwd += fmt.Sprintf("grater:x:%d:%d:grater:%s:/bin/sh\n", uid, gid, home)
+	}
+	return passwd
+}
+
+// runLabel is the container and volume label holding the run ID.
+const runLabel = "grater.run"
+
+// runContainer runs grater-agent for job in a new container, restricted to
+// stage if set. extra holds additional docker run options.
+func (d DockerRunner) runContainer(ctx context.Context, job Job, logs io.Writer, stage string, extra ...string) (DualResult, error) {
+	// The container is removed by hand so its OOM state can be inspected
+	name := d.containerName(job, stage)
+	defer exec.Command("docker", "rm", "-f", name).Run()
+
+	args, err := d.runArgs(job, name, stage, extra)
+	if err != nil {
+		return DualResult{}, err
+	}
+
+	// Killing the docker client leaves the container running, so
+	// cancellation removes the container instead
+	cmd := exec.CommandContext(ctx, "docker", args...)
+	cmd.Cancel = func() error {
+		return exec.Command("docker", "rm", "-f", name).Run()
+	}
+	cmd.WaitDelay = 10 * time.Second
+
+	var stdout bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = logs
+
+	runErr := cmd.Run()
+	if err := ctx.Err(); err != nil {
+		return DualResult{}, err
+	}
+	oomKilled := containerOOMKilled(name)
+
+	rawJSON := bytes.TrimSpace(stdout.Bytes())
+
+	if len(rawJSON) == 0 {
+		if oomKilled {
+			r := newResult(job)
+			r.Error = oomReason(job)
+			r = failBoth(r, "", KindOOM, oomReason(job))
+			r.syncEnds()
+			return r, nil
+		}
+		if runErr != nil {
+			return DualResult{}, fmt.Errorf("container exited with error and produced no JSON: %v", runErr)
+		}
+		return DualResult{}, fmt.Errorf("container produced no JSON output (stdout was empty)")
+	}
+
+	var r DualResult
+	if err := json.Unmarshal(rawJSON, &r); err != nil {
+		return DualResult{}, fmt.Errorf("failed to parse container JSON: %v\nraw output: %s", err, string(rawJSON))
+	}
+
+	if r.Module == "" {
+		r.Module = job.Module
+	}
+	r.Repo = job.Repo
+	if len(r.Refs) != len(job.Refs) {
+		return DualResult{}, fmt.Errorf("container returned %d ref results, expected %d", len(r.Refs), len(job.Refs))
+	}
+	if job.LogDir != "" {
+		for _, ref := range r.all() {
+			ref.Log = hostLogPath(ref.Log, job.LogDir)
+		}
+	}
+	// A build or test process hit the memory limit; whatever it failed
+	// with, the cause was the limit.
+	if oomKilled {
+		for _, ref := range r.all() {
+			if !ref.Passed && ref.Error != "" {
+				ref.Kind = KindOOM
+			}
+		}
+	}
+	if r.Base.Ref == "" {
+		r.Base.Ref = job.Base
+	}
+	if r.Head.Ref == "" {
+		r.Head.Ref = job.Head
+	}
+
+	return r, nil
+}
+
+// runArgs are the docker run arguments of the container named name that
+// runs stage of job. The job reaches grater-agent as environment variables,
+// read back by jobFromEnv.
+func (d DockerRunner) runArgs(job Job, name, stage string, extra []string) ([]string, error) {
+	args := []string{
+		"run", "--name", name,
+		"--label", runLabel + "=" + d.RunID,
+		"--label", "grater.module=" + job.Module,
+		"-e", "MODULE=" + job.Module,
+		"-e", "REPO=" + job.Repo,
+		"-e", "BASE_REF=" + job.Base,
+		"-e", "HEAD_REF=" + job.Head,
+		"-e", "ONLY=" + job.Only,
+		"-e", "MODULE_COMMIT=" + job.ModuleCommit,
+		"-e", "BASE_VERSION=" + job.BaseVersion,
+		"-e", "HEAD_VERSION=" + job.HeadVersion,
+		"-e", "UPSTREAM_MODULE=" + job.UpstreamModule,
+		"-e", "STAGE=" + stage,
+		"-e", "HOME=" + containerHome,
+	}
+	// Run as the host user, so the caches and logs written through mounts
+	// can be pruned and removed without root
+	if uid := os.Getuid(); uid >= 0 {
+		args = append(args, "--user", fmt.Sprintf("%d:%d", uid, os.Getgid()))
+	}
+	if d.Passwd != "" {
+		args = append(args, "-v", d.Passwd+":/etc/passwd:ro")
+	}
+	if job.CloneURL != "" {
+		args = append(args, "-e", "CLONE_URL="+job.CloneURL)
+	}
+	if job.CloneSSH {
+		args = append(args, "-e", "CLONE_SSH=1")
+	}
+	for _, kv := range d.Env {
+		args = append(args, "-e", kv)
+		// A file:// proxy is mounted at the same path, so GOPROXY stays valid
+		if key, value, _ := strings.Cut(kv, "="); key == "GOPROXY" {
+			for _, p := range strings.FieldsFunc(value, func(r rune) bool { return r == ',' || r == '|' }) {
+				if dir := FileProxyDir(p); dir != "" {
+					args = append(args, "-v", dir+":"+dir+":ro")
+				}
+			}
+		}
+	}
+	if job.GitMirror != "" {
+		args = append(args, "-v", job.GitMirror+":"+job.GitMirror+":ro", "-e", "GIT_MIRROR="+job.GitMirror)
+	}
+	// The test stage of an isolated job runs the dependent's code without
+	// network, so it never needs credentials
+	if stage != StageTest {
+		args = append(args, d.Credentials.dockerArgs()...)
+	}
+	// Local checkouts are mounted read-only and used as the replace target
+	if job.BaseDir != "" {
+		args = append(args, "-v", job.BaseDir+":/src/base:ro", "-e", "BASE_DIR=/src/base")
+	}
+	if job.HeadDir != "" {
+		args = append(args, "-v", job.HeadDir+":/src/head:ro", "-e", "HEAD_DIR=/src/head")
+	}
+	// Ref lists are passed one ref per line, as refs can't contain newlines
+	if len(job.Refs) > 0 {
+		refDirs := make([]string, len(job.Refs))
+		for i, dir := range job.RefDirs {
+			if dir != "" {
+				refDirs[i] = fmt.Sprintf("/src/ref-%d", i+1)
+				args = append(args, "-v", dir+":"+refDirs[i]+":ro")
+			}
+		}
+		args = append(args,
+			"-e", "REFS="+strings.Join(job.Refs, "\n"),
+			"-e", "REF_DIRS="+strings.Join(refDirs, "\n"),
+		)
+	}
+	// Logs are written by the agent straight into the host's log dir
+	if job.LogDir != "" {
+		if err := os.MkdirAll(job.LogDir, 0755); err != nil {
+			return nil, fmt.Errorf("failed to create log dir: %w", err)
+		}
+		args = append(args, "-v", job.LogDir+":/logs", "-e", "LOG_DIR=/logs")
+	}
+	if job.CPUs != "" {
+		args = append(args, "--cpus", job.CPUs, "-e", "CPUS="+job.CPUs)
+	}
+	// Without swap the kernel kills the container instead of thrashing
+	if job.Memory != "" {
+		args = append(args, "--memory", job.Memory, "--memory-swap", job.Memory, "-e", "MEMORY="+job.Memory)
+	}
+	if d.Caches != nil {
+		args = append(args,
+			"-v", d.Caches.ModCache+":/go/pkg/mod", "-e", "GOMODCACHE=/go/pkg/mod",
+			"-v", d.Caches.BuildCache+":/cache/go-build", "-e", "GOCACHE=/cache/go-build",
+		)
+	}
+	args = append(args, extra...)
+	args = append(args, d.Image)
+	return args, nil
+}
+
+// hostLogPath maps a log path inside the container to the host's log dir.
+func hostLogPath(path, logDir string) string {
+	if !strings.HasPrefix(path, "/logs/") {
+		return path
+	}
+	return filepath.Join(logDir, strings.TrimPrefix(path, "/logs/"))
+}
+
+// containerName names the container or volume of a job from the run ID,
+// the module and the part of the job it runs.
+func (d DockerRunner) containerName(job Job, suffix string) string {
+	parts := []string{"grater", d.RunID, job.Module, job.Only, suffix}
+	parts = slices.DeleteFunc(parts, func(p string) bool { return p == "" })
+	return invalidNameChars.ReplaceAllString(strings.Join(parts, "-"), "_")
+}
+
+var invalidNameChars = regexp.MustCompile(`[^a-zA-Z0-9_.-]`)
+
+// RemoveContainers force-removes every container and volume still left
+// from the run. Containers are removed as their jobs end, so this only
+// finds anything after an interrupt.
+func (d DockerRunner) RemoveContainers() error {
+	filter := "label=" + runLabel + "=" + d.RunID
+	out, err := exec.Command("docker", "ps", "-aq", "--filter", filter).Output()
+	if err != nil {
+		return fmt.Errorf("failed to list containers: %w", err)
+	}
+	if ids := strings.Fields(string(out)); len(ids) > 0 {
+		if err := exec.Command("docker", append([]string{"rm", "-f"}, ids...)...).Run(); err != nil {
+			return fmt.Errorf("failed to remove containers: %w", err)
+		}
+	}
+	out, err = exec.Command("docker", "volume", "ls", "-q", "--filter", filter).Output()
+	if err != nil {
+		return fmt.Errorf("failed to list volumes: %w", err)
+	}
+	if names := strings.Fields(string(out)); len(names) > 0 {
+		if err := exec.Command("docker", append([]string{"volume", "rm", "-f"}, names...)...).Run(); err != nil {
+			return fmt.Errorf("failed to remove volumes: %w", err)
+		}
+	}
+	return nil
+}
+
+// containerOOMKilled reports whether docker killed the container, or a
+// process in it, for exceeding its memory limit.
+func containerOOMKilled(name string) bool {
+	out, err := exec.Command("docker", "inspect", "--format", "{{.State.OOMKilled}}", name).Output()
+	return err == nil && strings.TrimSpace(string(out)) == "true"
+}
+
+func oomReason(job Job) string {
+	if job.Memory == "" {
+		return "container killed: out of memory"
+	}
+	return fmt.Sprintf("container killed: out of memory (limit %s)", job.Memory)
+}
@@ -0,0 +1,84 @@
+package internal
+
+import (
+	"slices"
+	"strings"
+)
+
+// Phases a ref goes through. RefResult.Phase records the one that failed.
+const (
+	PhaseClone    = "clone"
+	PhaseFetch    = "fetch"
+	PhaseCheckout = "checkout"
+	PhaseReplace  = "replace"
+	PhaseDownload = "download"
+	PhaseBuild    = "build"
+	PhaseVet      = "vet"
+	PhaseTest     = "test"
+)
+
+// Kinds of failure recorded in RefResult.Kind.
+const (
+	KindTimeout     = "timeout"
+	KindInfra       = "infra"
+	KindCompile     = "compile"
+	KindTestFailure = "test-failure"
+	KindPanic       = "panic"
+	KindOOM         = "oom"
+	KindNetwork     = "network"
+)
+
+// RealFailure reports whether r failed because of the dependent's code
+// (a compile error, failing test or panic) rather than infrastructure noise
+// such as a timeout, a missing ref, an OOM kill or missing network. Results
+// without a kind, written before kinds were recorded, count as real failures.
+func (r RefResult) RealFailure() bool {
+	if r.Passed || r.Skipped {
+		return false
+	}
+	switch r.Kind {
+	case "", KindCompile, KindTestFailure, KindPanic:
+		return true
+	}
+	return false
+}
+
+// fail marks res as failed in phase. Timeouts are also marked as skipped,
+// since they say nothing about the code under test.
+func fail(res *RefResult, phase, kind, msg string) {
+	res.Passed = false
+	res.Phase = phase
+	res.Kind = kind
+	res.Error = msg
+	if kind == KindTimeout {
+		res.Skipped = true
+	}
+}
+
+// networkErrors are what a build or test prints when it needs the network
+// and can't reach it.
+var networkErrors = []string{
+	"module lookup disabled by GOPROXY=off",
+	"network is unreachable",
+	"no such host",
+	"Temporary failure in name resolution",
+}
+
+// failureKind refines the kind of a failed build or test step from output,
+// which holds only what failed tests, packages or builds printed, and from
+// exitErr, the error of the go command. A go command killed by a signal is
+// OOM only under a memory limit; what tests print never decides it, as a
+// test may kill its own subprocesses. Test binaries that panicked are
+// reported as panics. Network errors only mean a network failure when the
+// step ran without network; otherwise they are the code's own failure.
+func failureKind(output, exitErr, fallback string, noNetwork, memoryLimit bool) string {
+	switch {
+	case memoryLimit && strings.Contains(exitErr, "signal: killed"):
+		return KindOOM
+	case noNetwork && slices.ContainsFunc(networkErrors, func(e string) bool { return strings.Contains(output, e) }):
+		return KindNetwork
+	case fallback == KindTestFailure && strings.Contains(output, "panic: "):
+		return KindPanic
+	}
+	return fallback
+}
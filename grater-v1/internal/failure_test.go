@@ -0,0 +1,52 @@
+package internal
+
+import "testing"
+
+func TestFailureKind(t *testing.T) {
+	for _, tc := range []struct {
+		name        string
+		output      string
+		exitErr     string
+		fallback    string
+		noNetwork   bool
+		memoryLimit bool
+		want        string
+	}{
+		{"test failure", "--- FAIL: TestX\n", "exit status 1", KindTestFailure, false, false, KindTestFailure},
+		{"compile error", "./a.go:1: undefined: x\n", "exit status 1", KindCompile, false, false, KindCompile},
+		{"panic", "panic: nil map\n", "exit status 1", KindTestFailure, false, false, KindPanic},
+		{"panic while building", "panic: in generator\n", "exit status 1", KindCompile, false, false, KindCompile},
+		{"test prints out of memory", "fatal error: runtime: out of memory\n", "exit status 1", KindTestFailure, false, true, KindTestFailure},
+		{"test subprocess killed", "--- FAIL: TestRun (0.10s)\n    run_test.go:9: exec: signal: killed\n", "exit status 1", KindTestFailure, false, true, KindTestFailure},
+		{"go command killed under memory limit", "", "signal: killed", KindCompile, false, true, KindOOM},
+		{"go command killed without memory limit", "", "signal: killed", KindCompile, false, false, KindCompile},
+		{"network error without network", "dial tcp: lookup x: no such host\n", "exit status 1", KindTestFailure, true, false, KindNetwork},
+		{"network error with network", "dial tcp: lookup x: no such host\n", "exit status 1", KindTestFailure, false, false, KindTestFailure},
+		{"GOPROXY=off", "module lookup disabled by GOPROXY=off\n", "exit status 1", KindCompile, true, false, KindNetwork},
+	} {
+		if got := failureKind(tc.output, tc.exitErr, tc.fallback, tc.noNetwork, tc.memoryLimit); got != tc.want {
+			t.Errorf("%s: failureKind() = %q, want %q", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestRealFailure(t *testing.T) {
+	for _, tc := range []struct {
+		res  RefResult
+		want bool
+	}{
+		{RefResult{Passed: true}, false},
+		{RefResult{Skipped: true, Kind: KindTimeout}, false},
+		{RefResult{Kind: KindTestFailure}, true},
+		{RefResult{Kind: KindCompile}, true},
+		{RefResult{Kind: KindPanic}, true},
+		{RefResult{}, true},
+		{RefResult{Kind: KindOOM}, false},
+		{RefResult{Kind: KindNetwork}, false},
+		{RefResult{Kind: KindInfra}, false},
+	} {
+		if got := tc.res.RealFailure(); got != tc.want {
+			t.Errorf("RealFailure(%+v) = %v, want %v", tc.res, got, tc.want)
+		}
+	}
+}
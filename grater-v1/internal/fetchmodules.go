@@ -1,6 +1,7 @@
 package internal
 
 import (
+	"cmp"
 	"encoding/json"
 	"fmt"
 	"net/http"
@@ -56,39 +57,59 @@ func FetchAndRankWithScorecard(limit int, module string) ([]string, error) {
 		return nil, err
 	}
 
-	unique := make(map[string]bool)
-	var roots []string
-
+	var paths []string
 	doc.Find(".ImportedBy-details a").Each(func(i int, s *goquery.Selection) {
-		path := strings.TrimSpace(s.Text())
-		if strings.HasPrefix(strings.ToLower(path), "github.com/") {
-			root := strings.ToLower(getRootModule(path))
-			if !unique[root] {
-				unique[root] = true
-				roots = append(roots, root)
-			}
+		if path := strings.TrimSpace(s.Text()); path != "" {
+			paths = append(paths, path)
 		}
 	})
 
-	fmt.Printf("📡 Found %d unique projects.\n", len(roots))
+	// Importers are package paths on any host; resolve each to the module
+	// that provides it, which may be nested in its repo, and score it by its
+	// repo URL. Without a proxy that knows the module, the repo root is the
+	// best guess.
+	unique := make(map[string]bool)
+	var modules []string
+	repoPaths := make(map[string]string)
+	for _, path := range paths {
+		if unique[strings.ToLower(path)] {
+			continue
+		}
+		module := ModuleOfPackage(path)
+		src, err := ResolveModule(cmp.Or(module, path))
+		if err != nil {
+			fmt.Printf("⚠️  Skipping %s: %v\n", path, err)
+			continue
+		}
+		if module == "" {
+			src.Module = src.Root
+		}
+		if key := strings.ToLower(src.Module); !unique[key] {
+			unique[key] = true
+			modules = append(modules, src.Module)
+			repoPaths[src.Module] = cleanRepoURL(src.RepoURL)
+		}
+	}
+
+	fmt.Printf("📡 Found %d unique modules.\n", len(modules))
 
 	cache := loadCache()
 	var wg sync.WaitGroup
 	var mu sync.Mutex
-	scored := make([]moduleInfo, 0, len(roots))
+	scored := make([]moduleInfo, 0, len(modules))
 	
 	// Use a worker pool pattern instead of unbounded goroutines
 	numWorkers := 5
-	workChan := make(chan string, len(roots))
+	workChan := make(chan string, len(modules))
 	
 	// Start workers
 	for w := 0; w < numWorkers; w++ {
 		wg.Add(1)
-		go worker(w, workChan, &wg, &mu, &scored, cache)
+		go worker(w, workChan, &wg, &mu, &scored, cache, repoPaths)
 	}
 	
 	// Send work
-	for _, p := range roots {
+	for _, p := range modules {
 		workChan <- p
 	}
 	close(workChan)
@@ -108,7 +129,7 @@ func FetchAndRankWithScorecard(limit int, module string) ([]string, error) {
 	return results, nil
 }
 
-func worker(id int, jobs <-chan string, wg *sync.WaitGroup, mu *sync.Mutex, scored *[]moduleInfo, cache map[string]float64) {
+func worker(id int, jobs <-chan string, wg *sync.WaitGroup, mu *sync.Mutex, scored *[]moduleInfo, cache map[string]float64, repoPaths map[string]string) {
 	defer wg.Done()
 	
 	// Create a separate HTTP client for each worker to avoid connection contention
@@ -123,7 +144,7 @@ func worker(id int, jobs <-chan string, wg *sync.WaitGroup, mu *sync.Mutex, scor
 	for path := range jobs {
 		score, exists := cache[path]
 		if !exists || score == 0.0 {
-			score = fetchScorecardScoreWithClient(workerClient, path)
+			score = fetchScorecardScoreWithClient(workerClient, repoPaths[path])
 			if score > 0.0 {
 				mu.Lock()
 				cache[path] = score
@@ -178,14 +199,6 @@ func fetchScorecardScore(path string) float64 {
 	return fetchScorecardScoreWithClient(httpClient, path)
 }
 
-func getRootModule(path string) string {
-	parts := strings.Split(path, "/")
-	if len(parts) > 3 && parts[0] == "github.com" {
-		return strings.Join(parts[:3], "/")
-	}
-	return path
-}
-
 func loadCache() map[string]float64 {
 	cache := make(map[string]float64)
 	data, err := os.ReadFile(".grater/cache.json")
@@ -205,6 +218,11 @@ func cleanRepoURL(url string) string {
 	url = strings.TrimSuffix(url, ".git")
 	url = strings.TrimPrefix(url, "https://")
 	url = strings.TrimPrefix(url, "http://")
+	url = strings.TrimPrefix(url, "ssh://")
+	// Drop credentials, e.g. user:token@github.com/foo/bar
+	if at := strings.Index(url, "@"); at >= 0 && !strings.HasPrefix(url, "git@") && at < strings.Index(url+"/", "/") {
+		url = url[at+1:]
+	}
 	if strings.HasPrefix(url, "git@") {
 		url = strings.Replace(url, ":", "/", 1)
 		url = strings.TrimPrefix(url, "git@")
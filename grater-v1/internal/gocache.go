@@ -0,0 +1,158 @@
+package internal
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// GoCaches are host directories shared by every module of a run so that
+// dependencies are downloaded and compiled only once.
+type GoCaches struct {
+	ModCache   string // GOMODCACHE
+	BuildCache string // GOCACHE
+}
+
+// EnsureGoCaches creates the shared Go caches under ws/cache.
+func EnsureGoCaches(ws string) (GoCaches, error) {
+	caches := GoCaches{
+		ModCache:   filepath.Join(ws, "cache", "gomod"),
+		BuildCache: filepath.Join(ws, "cache", "gobuild"),
+	}
+	for _, dir := range []string{caches.ModCache, caches.BuildCache} {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return GoCaches{}, fmt.Errorf("failed to create cache dir %s: %w", dir, err)
+		}
+	}
+	return caches, nil
+}
+
+// DirSize returns the total size of the regular files under dir.
+func DirSize(dir string) (int64, error) {
+	var size int64
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if d.Type().IsRegular() {
+			info, err := d.Info()
+			if err != nil {
+				return err
+			}
+			size += info.Size()
+		}
+		return nil
+	})
+	return size, err
+}
+
+// RemoveModCache deletes a module cache. Go makes the extracted modules
+// read-only, so directories are made writable first.
+func RemoveModCache(dir string) error {
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err == nil && d.IsDir() {
+			err = os.Chmod(path, 0755)
+		}
+		return err
+	})
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err := os.RemoveAll(dir); err != nil {
+		return err
+	}
+	return os.MkdirAll(dir, 0755)
+}
+
+// TrimBuildCache deletes the least recently used files of a build cache
+// until it is no larger than maxBytes. It returns the number of bytes freed.
+func TrimBuildCache(dir string, maxBytes int64) (int64, error) {
+	type entry struct {
+		path    string
+		size    int64
+		modTime time.Time
+	}
+	var entries []entry
+	var total int64
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		entries = append(entries, entry{path, info.Size(), info.ModTime()})
+		total += info.Size()
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	// Go refreshes the mtime of cache entries it uses, so oldest is least used
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].modTime.Before(entries[j].modTime)
+	})
+
+	var freed int64
+	for _, e := range entries {
+		if total-freed <= maxBytes {
+			break
+		}
+		if err := os.Remove(e.path); err != nil {
+			return freed, err
+		}
+		freed += e.size
+	}
+	return freed, nil
+}
+
+// ParseSize parses sizes like "512MB", "10GB" or a plain number of bytes.
+func ParseSize(size string) (int64, error) {
+	s := strings.ToUpper(strings.TrimSpace(size))
+	units := []struct {
+		suffix string
+		factor int64
+	}{
+		{"TB", 1 << 40}, {"GB", 1 << 30}, {"MB", 1 << 20}, {"KB", 1 << 10},
+		{"T", 1 << 40}, {"G", 1 << 30}, {"M", 1 << 20}, {"K", 1 << 10}, {"B", 1},
+	}
+	factor := int64(1)
+	for _, u := range units {
+		if strings.HasSuffix(s, u.suffix) {
+			s = strings.TrimSpace(strings.TrimSuffix(s, u.suffix))
+			factor = u.factor
+			break
+		}
+	}
+	n, err := strconv.ParseFloat(s, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid size %q", size)
+	}
+	return int64(n * float64(factor)), nil
+}
+
+// FormatSize renders a byte count for humans.
+func FormatSize(n int64) string {
+	switch {
+	case n >= 1<<30:
+		return fmt.Sprintf("%.1fGB", float64(n)/(1<<30))
+	case n >= 1<<20:
+		return fmt.Sprintf("%.1fMB", float64(n)/(1<<20))
+	case n >= 1<<10:
+		return fmt.Sprintf("%.1fKB", float64(n)/(1<<10))
+	}
+	return fmt.Sprintf("%dB", n)
+}
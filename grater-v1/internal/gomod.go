@@ -0,0 +1,242 @@
+package internal
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// goModRequire is a require directive from `go mod edit -json`.
+type goModRequire struct {
+	Path     string
+	Version  string
+	Indirect bool
+}
+
+// goModFile is the part of `go mod edit -json` output grater uses.
+type goModFile struct {
+	Module struct {
+		Path string
+	}
+	Require []goModRequire
+}
+
+// readGoMod parses the go.mod file in dir.
+func readGoMod(dir string) (goModFile, error) {
+	var f goModFile
+	cmd := exec.Command("go", "mod", "edit", "-json")
+	cmd.Dir = dir
+	out, err := cmd.Output()
+	if err != nil {
+		return f, fmt.Errorf("failed to read go.mod in %s: %w", dir, err)
+	}
+	if err := json.Unmarshal(out, &f); err != nil {
+		return f, fmt.Errorf("failed to parse go.mod in %s: %w", dir, err)
+	}
+	return f, nil
+}
+
+// readModulePath returns the path from the module directive of a go.mod.
+func readModulePath(gomod string) (string, error) {
+	data, err := os.ReadFile(gomod)
+	if err != nil {
+		return "", err
+	}
+	path := parseModulePath(data)
+	if path == "" {
+		return "", fmt.Errorf("no module directive in %s", gomod)
+	}
+	return path, nil
+}
+
+// parseModulePath returns the path from the module directive of the go.mod
+// contents in data, or "" if there is none.
+func parseModulePath(data []byte) string {
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if i := strings.Index(line, "//"); i >= 0 {
+			line = strings.TrimSpace(line[:i])
+		}
+		if !strings.HasPrefix(line, "module") {
+			continue
+		}
+		path := strings.TrimSpace(strings.TrimPrefix(line, "module"))
+		if unquoted, err := strconv.Unquote(path); err == nil {
+			path = unquoted
+		}
+		if path != "" {
+			return path
+		}
+	}
+	return ""
+}
+
+// ModulePath returns the path of the module whose go.mod is in dir.
+func ModulePath(dir string) (string, error) {
+	return readModulePath(filepath.Join(dir, "go.mod"))
+}
+
+// findModules returns the directory of every module in the tree at root,
+// keyed by module path. Like the go command, it skips vendor and testdata
+// directories and directories starting with "." or "_".
+func findModules(root string) (map[string]string, error) {
+	modules := make(map[string]string)
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			name := d.Name()
+			if path != root && (name == "vendor" || name == "testdata" ||
+				strings.HasPrefix(name, ".") || strings.HasPrefix(name, "_")) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if d.Name() != "go.mod" {
+			return nil
+		}
+		modPath, err := readModulePath(path)
+		if err != nil {
+			return nil
+		}
+		modules[modPath] = filepath.Dir(path)
+		return nil
+	})
+	return modules, err
+}
+
+// notRequiredError reports that a dependent doesn't require any module of
+// the upstream checkout, so a replace would silently have no effect.
+type notRequiredError struct {
+	reason string
+}
+
+func (e *notRequiredError) Error() string {
+	return e.reason
+}
+
+var majorSuffix = regexp.MustCompile(`/v[0-9]+$`)
+
+// notRequired explains why none of the upstream modules were replaced,
+// pointing out when the dependent uses another major version of the root
+// module (e.g. it requires example.com/mod but the checkout is example.com/mod/v2).
+func notRequired(dependent goModFile, rootModule string) error {
+	if rootModule == "" {
+		return &notRequiredError{"dependent does not require any module of the upstream repo (no go.mod at repo root)"}
+	}
+	prefix := majorSuffix.ReplaceAllString(rootModule, "")
+	for _, req := range dependent.Require {
+		if req.Path == prefix || majorSuffix.ReplaceAllString(req.Path, "") == prefix {
+			return &notRequiredError{fmt.Sprintf("dependent does not require %s (it requires %s %s, a different major version)",
+				rootModule, req.Path, req.Version)}
+		}
+	}
+	return &notRequiredError{fmt.Sprintf("dependent does not require %s", rootModule)}
+}
+
+// replaceUpstreamModules adds a replace directive to the dependent in modDir
+// for every module in the upstream checkout at srcDir that the dependent
+// requires, directly or indirectly. Module paths, including any major version
+// suffix, come from the checkout's go.mod files rather than the repo URL. It
+// returns the replaced module paths, or a *notRequiredError if there were none.
+func replaceUpstreamModules(modDir, srcDir string) ([]string, error) {
+	dependent, err := readGoMod(modDir)
+	if err != nil {
+		return nil, err
+	}
+	upstream, err := findModules(srcDir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to scan %s for modules: %w", srcDir, err)
+	}
+
+	var replaced []string
+	args := []string{"mod", "edit"}
+	for _, req := range dependent.Require {
+		dir, ok := upstream[req.Path]
+		if !ok {
+			continue
+		}
+		args = append(args, "-dropreplace="+req.Path, "-replace="+req.Path+"="+dir)
+		replaced = append(replaced, req.Path)
+	}
+	if len(replaced) == 0 {
+		rootModule, _ := readModulePath(filepath.Join(srcDir, "go.mod"))
+		return nil, notRequired(dependent, rootModule)
+	}
+
+	cmd := exec.Command("go", args...)
+	cmd.Dir = modDir
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return nil, fmt.Errorf("go mod edit failed: %v: %s", err, strings.TrimSpace(string(out)))
+	}
+	sort.Strings(replaced)
+	return replaced, nil
+}
+
+// requiredRootModule returns the version of rootModule, the module at the
+// root of the upstream repo, that the dependent in modDir requires, or a
+// *notRequiredError if it doesn't require it.
+func requiredRootModule(modDir, rootModule string) (goModRequire, error) {
+	dependent, err := readGoMod(modDir)
+	if err != nil {
+		return goModRequire{}, err
+	}
+	for _, req := range dependent.Require {
+		if rootModule != "" && req.Path == rootModule {
+			return req, nil
+		}
+	}
+	return goModRequire{}, notRequired(dependent, rootModule)
+}
+
+// goSumHashes returns the go.sum hashes of module at version in modDir: the
+// hash of the module itself and of its go.mod.
+func goSumHashes(modDir, module, version string) (sum, goModSum string) {
+	data, err := os.ReadFile(filepath.Join(modDir, "go.sum"))
+	if err != nil {
+		return "", ""
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) != 3 || fields[0] != module {
+			continue
+		}
+		switch fields[1] {
+		case version:
+			sum = fields[2]
+		case version + "/go.mod":
+			goModSum = fields[2]
+		}
+	}
+	return sum, goModSum
+}
+
+// findModuleDir returns the directory of module inside the repo checkout at
+// root, relative to root. It is empty when the module is at the root or
+// can't be found.
+func findModuleDir(root, module string) string {
+	modules, err := findModules(root)
+	if err != nil {
+		return ""
+	}
+	dir, ok := modules[module]
+	if !ok {
+		return ""
+	}
+	rel, err := filepath.Rel(root, dir)
+	if err != nil || rel == "." {
+		return ""
+	}
+	return rel
+}
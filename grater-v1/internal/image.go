@@ -0,0 +1,108 @@
+package internal
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// runnerDockerfile is the Dockerfile of the runner image inside its build
+// context.
+const runnerDockerfile = "docker/dockerfile"
+
+// RunnerImageRef is the runner image built from src, tagged with a hash of
+// src under the repository of name, e.g. grater-runner:3f2a9c1e0b7d. The tag
+// only changes when the Dockerfile or the agent's sources do.
+func RunnerImageRef(name string, src fs.FS) (string, error) {
+	h := sha256.New()
+	err := walkRunnerSource(src, func(path string, data []byte) error {
+		fmt.Fprintf(h, "%s %d\n", path, len(data))
+		h.Write(data)
+		return nil
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to hash runner image sources: %w", err)
+	}
+	return imageRepository(name) + ":" + hex.EncodeToString(h.Sum(nil))[:12], nil
+}
+
+// walkRunnerSource calls fn with every file of the build context src, in
+// lexical order so hashes are stable. Tests aren't part of the image, so
+// _test.go files are left out and editing them changes neither the tag nor
+// the build.
+func walkRunnerSource(src fs.FS, fn func(path string, data []byte) error) error {
+	return fs.WalkDir(src, ".", func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() || strings.HasSuffix(path, "_test.go") {
+			return err
+		}
+		data, err := fs.ReadFile(src, path)
+		if err != nil {
+			return err
+		}
+		return fn(path, data)
+	})
+}
+
+// imageRepository strips the tag or digest from an image name.
+func imageRepository(name string) string {
+	name, _, _ = strings.Cut(name, "@")
+	if i := strings.LastIndex(name, ":"); i > strings.LastIndex(name, "/") {
+		name = name[:i]
+	}
+	return name
+}
+
+// ImageExists reports whether image is available locally.
+func ImageExists(image string) bool {
+	return exec.Command("docker", "image", "inspect", image).Run() == nil
+}
+
+// BuildRunnerImage builds the runner image from the build context src and
+// tags it as ref and as the latest image of its repository.
+func BuildRunnerImage(ref string, src fs.FS) error {
+	dir, err := os.MkdirTemp("", "grater-image-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+	err = walkRunnerSource(src, func(path string, data []byte) error {
+		dst := filepath.Join(dir, filepath.FromSlash(path))
+		if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+			return err
+		}
+		return os.WriteFile(dst, data, 0644)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to write build context: %w", err)
+	}
+
+	build := exec.Command(
+		"docker", "build",
+		"-t", ref,
+		"-t", imageRepository(ref),
+		"-f", filepath.Join(dir, runnerDockerfile),
+		dir,
+	)
+	build.Stdout = os.Stdout
+	build.Stderr = os.Stderr
+	if err := build.Run(); err != nil {
+		return fmt.Errorf("docker build failed: %w", err)
+	}
+	return nil
+}
+
+// PullImage pulls image from its registry.
+func PullImage(image string) error {
+	pull := exec.Command("docker", "pull", image)
+	pull.Stdout = os.Stdout
+	pull.Stderr = os.Stderr
+	if err := pull.Run(); err != nil {
+		return fmt.Errorf("docker pull %s failed: %w", image, err)
+	}
+	return nil
+}
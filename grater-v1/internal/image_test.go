@@ -0,0 +1,32 @@
+package internal
+
+import (
+	"testing"
+	"testing/fstest"
+)
+
+func TestRunnerImageRefIgnoresTests(t *testing.T) {
+	src := fstest.MapFS{
+		"docker/dockerfile":        {Data: []byte("FROM golang\n")},
+		"internal/agent.go":        {Data: []byte("package internal\n")},
+		"internal/agent_test.go":   {Data: []byte("package internal\n")},
+		"cmd/grater-agent/main.go": {Data: []byte("package main\n")},
+	}
+	ref := func() string {
+		r, err := RunnerImageRef("grater-runner:latest", src)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return r
+	}
+
+	before := ref()
+	src["internal/agent_test.go"] = &fstest.MapFile{Data: []byte("package internal\n\n// edited\n")}
+	if after := ref(); after != before {
+		t.Errorf("editing a test changed the image tag from %s to %s", before, after)
+	}
+	src["internal/agent.go"] = &fstest.MapFile{Data: []byte("package internal\n\n// edited\n")}
+	if after := ref(); after == before {
+		t.Errorf("editing the agent kept the image tag %s", before)
+	}
+}
@@ -0,0 +1,668 @@
+package internal
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// errTimeout is returned by localRun.exec when a step exceeds its timeout.
+var errTimeout = errors.New("timed out")
+
+// Stages of a run split for network isolation. The prepare stage does
+// everything that needs the network; the test stage builds and tests what
+// a prepare stage left in the work dir.
+const (
+	StagePrepare = "prepare"
+	StageTest    = "test"
+)
+
+// LocalRunner runs each job directly on the host in a temporary directory,
+// going through the clone → replace → build → test flow. It is used on
+// machines without Docker, and by grater-agent inside the runner image.
+type LocalRunner struct {
+	Timeout time.Duration
+
+	// Tags are passed to go build and go test with -tags.
+	Tags []string
+
+	// Caches, when set, are shared by every job. Otherwise each job gets
+	// its own caches that are deleted with its work dir.
+	Caches *GoCaches
+
+	// WorkDir, when set, is used instead of a temporary directory and kept
+	// after the run, so a later stage can pick up where this one stopped.
+	WorkDir string
+
+	// Stage restricts the run to StagePrepare or StageTest. Empty runs both.
+	Stage string
+
+	// Env holds KEY=VALUE settings, e.g. GOPRIVATE, that override the
+	// host's environment for every command.
+	Env []string
+
+	// NoNetwork says the job runs without network, e.g. in a container
+	// started with --network none. Only then are network errors reported as
+	// network failures rather than the code's own.
+	NoNetwork bool
+
+	// MemoryLimit says the job runs under a memory limit, e.g. in a
+	// container started with --memory. Only then is a go command killed by
+	// a signal reported as out of memory.
+	MemoryLimit bool
+}
+
+func (l LocalRunner) Run(ctx context.Context, job Job, logs io.Writer) (DualResult, error) {
+	// Credentials in URLs never reach the logs or the result
+	redacted := newRedactWriter(logs)
+	defer redacted.Flush()
+	r, err := l.run(ctx, job, redacted)
+	r.syncEnds()
+	r.redact()
+	return r, err
+}
+
+func (l LocalRunner) run(ctx context.Context, job Job, logs io.Writer) (DualResult, error) {
+	r := newResult(job)
+
+	workDir := l.WorkDir
+	if workDir == "" {
+		var err error
+		workDir, err = os.MkdirTemp("", "grater-"+strings.ReplaceAll(job.Module, "/", "_")+"-")
+		if err != nil {
+			return r, fmt.Errorf("failed to create work dir: %w", err)
+		}
+		defer os.RemoveAll(workDir)
+	} else if err := os.MkdirAll(workDir, 0755); err != nil {
+		return r, fmt.Errorf("failed to create work dir: %w", err)
+	}
+
+	run := &localRun{
+		ctx:     ctx,
+		workDir: workDir,
+		timeout: l.Timeout,
+		cores:   runtime.GOMAXPROCS(0),
+		tags:    l.Tags,
+		env:     l.Env,
+
+		noNetwork:      l.NoNetwork,
+		memoryLimit:    l.MemoryLimit,
+		upstreamModule: job.UpstreamModule,
+	}
+	if run.timeout <= 0 {
+		run.timeout = 300 * time.Second
+	}
+	// GOMAXPROCS follows a container's CPU quota by default, but not on the
+	// host or when set in the environment, so the job's CPU limit caps
+	// parallelism too.
+	if cpus, err := strconv.ParseFloat(job.CPUs, 64); err == nil && cpus > 0 && int(math.Ceil(cpus)) < run.cores {
+		run.cores = int(math.Ceil(cpus))
+	}
+	if l.Caches != nil {
+		run.caches = *l.Caches
+	} else {
+		run.caches = GoCaches{
+			ModCache:   filepath.Join(workDir, "go-mod"),
+			BuildCache: filepath.Join(workDir, "go-build"),
+		}
+		run.privateCaches = true
+	}
+
+	if l.Stage == StageTest {
+		prepared, err := loadPrepared(workDir)
+		if err != nil {
+			return r, err
+		}
+		r = prepared
+		// Without network, missing modules fail fast instead of hanging
+		run.offline = true
+		run.noNetwork = true
+		run.subdir = findModuleDir(filepath.Join(workDir, "dependent-module"), job.Module)
+	}
+	refs := job.refs(&r)
+
+	// Each ref gets its own log file. Setup output goes to all of them, so
+	// each file tells the whole story for its ref. The test stage appends
+	// to what the prepare stage wrote.
+	refLogs := make(map[string]io.Writer)
+	setupLogs := []io.Writer{logs}
+	if job.LogDir != "" {
+		if err := os.MkdirAll(job.LogDir, 0755); err != nil {
+			return r, fmt.Errorf("failed to create log dir: %w", err)
+		}
+		flags := os.O_CREATE | os.O_WRONLY | os.O_TRUNC
+		if l.Stage == StageTest {
+			flags = os.O_CREATE | os.O_WRONLY | os.O_APPEND
+		}
+		for _, ref := range refs {
+			path := filepath.Join(job.LogDir, ref.name+".log")
+			f, err := os.OpenFile(path, flags, 0644)
+			if err != nil {
+				return r, fmt.Errorf("failed to create log file: %w", err)
+			}
+			defer f.Close()
+			w := newRedactWriter(f)
+			defer w.Flush()
+			refLogs[ref.name] = w
+			setupLogs = append(setupLogs, w)
+			ref.res.Log = path
+		}
+	}
+	run.logs = io.MultiWriter(setupLogs...)
+	refLog := func(ref jobRef) io.Writer {
+		if f, ok := refLogs[ref.name]; ok {
+			return io.MultiWriter(logs, f)
+		}
+		return logs
+	}
+
+	if l.Stage != StageTest {
+		fmt.Fprintf(run.logs, "📁 Workspace: %s\n", workDir)
+
+		// Clone dependency repo, unless every tested ref comes from a local
+		// checkout or is a version whose module path is already known
+		if slices.ContainsFunc(refs, func(ref jobRef) bool {
+			return ref.srcDir == "" && (ref.version == "" || job.UpstreamModule == "")
+		}) {
+			repoURL := job.repoCloneURL()
+			fmt.Fprintf(run.logs, "\n📦 Cloning dependency repo: %s\n", repoURL)
+			if err := run.exec(workDir, nil, "git", "clone", "--depth", "1", repoURL, "dependency-repo"); err != nil {
+				fmt.Fprintln(run.logs, "❌ Failed to clone dependency repo")
+				return failBoth(r, PhaseClone, cloneKind(err), "Clone failed or timed out"), ctx.Err()
+			}
+		}
+
+		fmt.Fprintf(run.logs, "📦 Cloning dependent module: %s\n", job.Module)
+		if err := run.exec(workDir, nil, "git", "clone", "--depth", "1", job.moduleCloneURL(), "dependent-module"); err != nil {
+			fmt.Fprintf(run.logs, "❌ Failed to clone module: %s\n", job.Module)
+			return failBoth(r, PhaseClone, cloneKind(err), "Module clone failed or timed out"), ctx.Err()
+		}
+		if job.ModuleCommit != "" {
+			if err := run.pinModule(job.ModuleCommit); err != nil {
+				fmt.Fprintf(run.logs, "❌ Failed to check out %s at %s\n", job.Module, job.ModuleCommit)
+				return failBoth(r, PhaseCheckout, cloneKind(err), "Module commit checkout failed"), ctx.Err()
+			}
+		}
+		r.ModuleCommit = run.revParseHead(filepath.Join(workDir, "dependent-module"))
+		// The module may live in a subdirectory of its repo
+		run.subdir = findModuleDir(filepath.Join(workDir, "dependent-module"), job.Module)
+		if run.subdir != "" {
+			fmt.Fprintf(run.logs, "📂 Module is in %s\n", run.subdir)
+		}
+
+		for _, ref := range refs {
+			run.logs = refLog(ref)
+			// Only the first module-level error is kept
+			if err := run.prepareRef(job.Module, ref, l.Stage == StagePrepare); err != nil && r.Error == "" {
+				r.Error = err.Error()
+			}
+		}
+
+		if l.Stage == StagePrepare {
+			if err := ctx.Err(); err != nil {
+				return r, err
+			}
+			return r, savePrepared(workDir, r)
+		}
+	}
+
+	if r.Error != "" {
+		return r, ctx.Err()
+	}
+	for _, ref := range refs {
+		if prepared(*ref.res) {
+			run.logs = refLog(ref)
+			run.buildAndTest(ref.res, ref.name)
+		}
+	}
+
+	return r, ctx.Err()
+}
+
+// prepared reports whether res made it through the prepare stage and still
+// has to be built and tested.
+func prepared(res RefResult) bool {
+	return !res.Passed && res.Error == ""
+}
+
+func savePrepared(workDir string, r DualResult) error {
+	data, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(filepath.Join(workDir, "prepared.json"), data, 0644); err != nil {
+		return fmt.Errorf("failed to save prepare stage: %w", err)
+	}
+	return nil
+}
+
+func loadPrepared(workDir string) (DualResult, error) {
+	var r DualResult
+	data, err := os.ReadFile(filepath.Join(workDir, "prepared.json"))
+	if err != nil {
+		return r, fmt.Errorf("no prepare stage found in %s: %w", workDir, err)
+	}
+	if err := json.Unmarshal(data, &r); err != nil {
+		return r, fmt.Errorf("failed to parse prepare stage: %w", err)
+	}
+	return r, nil
+}
+
+// failBoth marks both refs as failed before either could be tested. Unlike
+// skipBoth, the failure keeps its phase and kind.
+func failBoth(r DualResult, phase, kind, reason string) DualResult {
+	r = skipBoth(r, reason)
+	for _, ref := range r.all() {
+		ref.Phase, ref.Kind = phase, kind
+	}
+	return r
+}
+
+func cloneKind(err error) string {
+	if err == errTimeout {
+		return KindTimeout
+	}
+	return KindInfra
+}
+
+func skipBoth(r DualResult, reason string) DualResult {
+	for _, ref := range r.all() {
+		ref.Error, ref.Skipped = reason, true
+	}
+	return r
+}
+
+type localRun struct {
+	ctx           context.Context
+	workDir       string
+	timeout       time.Duration
+	cores         int
+	logs          io.Writer
+	caches        GoCaches
+	privateCaches bool
+	offline       bool
+	noNetwork     bool // network errors are expected, not the code's
+	memoryLimit   bool // kills are the memory limit's
+	tags          []string
+	env           []string
+	subdir        string // of the dependent module in its repo
+
+	upstreamModule string // root module of the upstream repo, if known
+}
+
+// exec runs a command in dir with the step timeout, sending its output to the
+// logs. If errOut is non-nil, the output is also captured there.
+func (l *localRun) exec(dir string, errOut *bytes.Buffer, name string, args ...string) error {
+	var out io.Writer = l.logs
+	if errOut != nil {
+		out = io.MultiWriter(l.logs, errOut)
+	}
+	return l.execTo(dir, out, out, name, args...)
+}
+
+// execTo is like exec but lets the caller choose where stdout and stderr go.
+func (l *localRun) execTo(dir string, stdout, stderr io.Writer, name string, args ...string) error {
+	ctx, cancel := context.WithTimeout(l.ctx, l.timeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, name, args...)
+	cmd.Dir = dir
+	cmd.Env = l.environ()
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+
+	err := cmd.Run()
+	// A cancelled run kills the step; that is not a failure of the step
+	if l.ctx.Err() != nil {
+		return l.ctx.Err()
+	}
+	if ctx.Err() == context.DeadlineExceeded {
+		return errTimeout
+	}
+	return err
+}
+
+func (l *localRun) environ() []string {
+	env := append(os.Environ(),
+		"GO111MODULE=on",
+		"GOMAXPROCS="+strconv.Itoa(l.cores),
+		"GOMODCACHE="+l.caches.ModCache,
+		"GOCACHE="+l.caches.BuildCache,
+	)
+	// Later entries win, so the job's settings override the host's
+	env = append(env, l.env...)
+	getenv := func(key string) string {
+		for i := len(env) - 1; i >= 0; i-- {
+			if v, ok := strings.CutPrefix(env[i], key+"="); ok {
+				return v
+			}
+		}
+		return ""
+	}
+	if l.privateCaches {
+		// Keep the module cache writable so the work dir can be removed
+		env = append(env, "GOFLAGS="+strings.TrimSpace(getenv("GOFLAGS")+" -modcacherw"))
+	}
+	for key, def := range map[string]string{
+		"GOPROXY":   "direct",
+		"GOSUMDB":   "off",
+		"GONOSUMDB": "*",
+	} {
+		if getenv(key) == "" {
+			env = append(env, key+"="+def)
+		}
+	}
+	// Without network, GOPROXY=off makes a missing module fail right away.
+	// Later entries win, so this overrides the default above.
+	if l.offline {
+		env = append(env, "GOPROXY=off")
+	}
+	return env
+}
+
+// prepareRef gets a copy of the dependent ready to be built against the
+// ref in res: it checks out the upstream ref, replaces the upstream modules
+// and downloads dependencies. If the ref has a srcDir it is used as the
+// replace target instead of fetching the ref, and if it has a version the
+// upstream module is moved to it with `go get` instead. With fetchAll, every
+// module needed to build and test is downloaded, so the test stage can run
+// without network.
+// A non-nil error means the comparison is meaningless for the whole module,
+// e.g. because the dependent doesn't require the upstream module.
+func (l *localRun) prepareRef(module string, ref jobRef, fetchAll bool) error {
+	res := ref.res
+	depDir := filepath.Join(l.workDir, "dependency-"+ref.name)
+	modDir := l.modDir(ref.name)
+
+	fmt.Fprintln(l.logs, "\n════════════════════════════════════════════════════════════════════════════")
+	fmt.Fprintf(l.logs, "🔍 Testing %s with dependency at %s: %s\n", module, ref.name, res.Ref)
+	fmt.Fprintln(l.logs, "════════════════════════════════════════════════════════════════════════════")
+
+	// Each ref gets its own worktrees, so both can be prepared before
+	// either is built
+	if err := l.exec(filepath.Join(l.workDir, "dependent-module"), nil, "git", "worktree", "add", "--detach", l.worktree(ref.name), "HEAD"); err != nil {
+		fmt.Fprintln(l.logs, "   ❌ Failed to check out dependent")
+		fail(res, PhaseCheckout, cloneKind(err), "Dependent checkout failed")
+		return nil
+	}
+
+	if ref.version != "" {
+		if err := l.getVersion(res, modDir, ref.version); err != nil {
+			return err
+		}
+	} else if err := l.replaceRef(res, modDir, depDir, ref.srcDir); err != nil {
+		return err
+	}
+	if res.Phase != "" {
+		return nil
+	}
+
+	if _, err := os.Stat(filepath.Join(modDir, "vendor")); err == nil {
+		os.RemoveAll(filepath.Join(modDir, "vendor"))
+		fmt.Fprintln(l.logs, "   📁 Removed vendor dir")
+	}
+
+	fmt.Fprintln(l.logs, "   📦 Downloading dependencies...")
+	if err := l.exec(modDir, nil, "go", "mod", "download"); err != nil {
+		if err == errTimeout {
+			fmt.Fprintln(l.logs, "   ⏰ Dependency download timed out")
+			fail(res, PhaseDownload, KindTimeout, "Dependency download timeout")
+			return nil
+		}
+		fmt.Fprintln(l.logs, "   ⚠️  go mod download had errors, continuing anyway...")
+	}
+
+	// go mod download skips modules that only tests of dependencies need;
+	// listing every package pulls those in and records their go.sum lines.
+	if fetchAll {
+		listArgs := append([]string{"list", "-mod=mod", "-deps", "-test"}, l.tagArgs()...)
+		if err := l.execTo(modDir, io.Discard, l.logs, "go", append(listArgs, "./...")...); err != nil {
+			if err == errTimeout {
+				fmt.Fprintln(l.logs, "   ⏰ Dependency download timed out")
+				fail(res, PhaseDownload, KindTimeout, "Dependency download timeout")
+				return nil
+			}
+			fmt.Fprintln(l.logs, "   ⚠️  go list had errors, continuing anyway...")
+		}
+	}
+	return nil
+}
+
+// replaceRef points the dependent in modDir at the upstream checkout of res:
+// srcDir if set, otherwise the ref fetched into depDir. Failures are recorded
+// in res; a non-nil error is a module-level one, as for prepareRef.
+func (l *localRun) replaceRef(res *RefResult, modDir, depDir, srcDir string) error {
+	cores := strconv.Itoa(l.cores)
+
+	if srcDir != "" {
+		fmt.Fprintf(l.logs, "   📂 Using local checkout: %s\n", srcDir)
+		depDir = srcDir
+	} else {
+		repoDir := filepath.Join(l.workDir, "dependency-repo")
+		fmt.Fprintf(l.logs, "   🔄 Fetching %s...\n", res.Ref)
+		if err := l.exec(repoDir, nil, "git", "fetch", "--jobs="+cores, "origin", res.Ref); err != nil {
+			if err == errTimeout {
+				fmt.Fprintln(l.logs, "   ⏰ Fetch timed out")
+				fail(res, PhaseFetch, KindTimeout, "Fetch timeout")
+			} else {
+				fmt.Fprintln(l.logs, "   ❌ Fetch failed")
+				fail(res, PhaseFetch, KindInfra, "Fetch failed: ref does not exist")
+			}
+			return nil
+		}
+
+		fmt.Fprintln(l.logs, "   🔄 Checking out FETCH_HEAD...")
+		if err := l.exec(repoDir, nil, "git", "worktree", "add", "--detach", depDir, "FETCH_HEAD"); err != nil {
+			if err == errTimeout {
+				fmt.Fprintln(l.logs, "   ⏰ Checkout timed out")
+				fail(res, PhaseCheckout, KindTimeout, "Checkout timeout")
+			} else {
+				fmt.Fprintln(l.logs, "   ❌ Checkout failed")
+				fail(res, PhaseCheckout, KindInfra, "Checkout failed")
+			}
+			return nil
+		}
+	}
+
+	replaced, err := replaceUpstreamModules(modDir, depDir)
+	if err != nil {
+		var notReq *notRequiredError
+		if errors.As(err, &notReq) {
+			fmt.Fprintf(l.logs, "   ❌ %s\n", notReq.reason)
+			fail(res, PhaseReplace, KindInfra, notReq.reason)
+			return err
+		}
+		fmt.Fprintf(l.logs, "   ❌ Failed to add replace directive: %v\n", err)
+		fail(res, PhaseReplace, KindInfra, "Failed to add replace directive")
+		return nil
+	}
+	res.Replacements = replaced
+	fmt.Fprintf(l.logs, "   🔁 Replaced %d upstream module(s): %s\n", len(replaced), strings.Join(replaced, ", "))
+	return nil
+}
+
+// getVersion upgrades or downgrades the upstream module required by the
+// dependent in modDir to a published version with `go get`, and records the
+// resolved version and its go.sum hashes in res. The upstream module is the
+// job's UpstreamModule, or else the one at the root of the dependency repo
+// clone. PinnedVersion leaves the dependent's go.mod as it is.
+func (l *localRun) getVersion(res *RefResult, modDir, version string) error {
+	rootModule := l.upstreamModule
+	if rootModule == "" {
+		rootModule, _ = readModulePath(filepath.Join(l.workDir, "dependency-repo", "go.mod"))
+	}
+	req, err := requiredRootModule(modDir, rootModule)
+	if err != nil {
+		var notReq *notRequiredError
+		if errors.As(err, &notReq) {
+			fmt.Fprintf(l.logs, "   ❌ %s\n", notReq.reason)
+			fail(res, PhaseReplace, KindInfra, notReq.reason)
+			return err
+		}
+		fmt.Fprintf(l.logs, "   ❌ Failed to read go.mod: %v\n", err)
+		fail(res, PhaseReplace, KindInfra, "Failed to read go.mod")
+		return nil
+	}
+
+	upstream := req.Path
+
+	if version == PinnedVersion {
+		fmt.Fprintf(l.logs, "   📌 Keeping the pinned version of %s\n", upstream)
+		res.Version = req.Version
+		res.Sum, res.GoModSum = goSumHashes(modDir, upstream, res.Version)
+		fmt.Fprintf(l.logs, "   📌 %s %s %s\n", upstream, res.Version, res.Sum)
+		return nil
+	}
+
+	fmt.Fprintf(l.logs, "   📥 go get %s@%s\n", upstream, version)
+	var errOut bytes.Buffer
+	if err := l.exec(modDir, &errOut, "go", "get", upstream+"@"+version); err != nil {
+		if err == errTimeout {
+			fmt.Fprintln(l.logs, "   ⏰ go get timed out")
+			fail(res, PhaseDownload, KindTimeout, "go get timeout")
+		} else {
+			fmt.Fprintln(l.logs, "   ❌ go get failed")
+			fail(res, PhaseDownload, KindInfra, "go get failed: "+errorExcerpt(errOut.String()))
+		}
+		return nil
+	}
+
+	var out bytes.Buffer
+	if err := l.execTo(modDir, &out, l.logs, "go", "list", "-mod=mod", "-m", "-f", "{{.Version}}", upstream); err == nil {
+		res.Version = strings.TrimSpace(out.String())
+	}
+	res.Sum, res.GoModSum = goSumHashes(modDir, upstream, res.Version)
+	fmt.Fprintf(l.logs, "   📌 %s %s %s\n", upstream, res.Version, res.Sum)
+	return nil
+}
+
+// buildAndTest builds and tests the dependent prepared by prepareRef for
+// side and records the outcome in res.
+func (l *localRun) buildAndTest(res *RefResult, side string) {
+	modDir := l.modDir(side)
+	cores := strconv.Itoa(l.cores)
+
+	if l.offline {
+		fmt.Fprintf(l.logs, "\n🔒 Building and testing %s (%s) without network\n", side, res.Ref)
+	}
+
+	fmt.Fprintf(l.logs, "   🔨 Building with %d cores...\n", l.cores)
+	var buildErr bytes.Buffer
+	buildArgs := append([]string{"build", "-p", cores, "-mod=mod"}, l.tagArgs()...)
+	if err := l.exec(modDir, &buildErr, "go", append(buildArgs, "./...")...); err != nil {
+		if err == errTimeout {
+			fmt.Fprintln(l.logs, "   ⏰ Build timed out")
+			fail(res, PhaseBuild, KindTimeout, "Build timeout")
+		} else {
+			fail(res, PhaseBuild, failureKind(buildErr.String(), err.Error(), KindCompile, l.noNetwork, l.memoryLimit), errorExcerpt(buildErr.String()))
+			fmt.Fprintf(l.logs, "   ❌ Build failed: %s\n", res.Error)
+		}
+		return
+	}
+
+	fmt.Fprintf(l.logs, "   🧪 Running tests with %d cores...\n", l.cores)
+	var events, testErr, failedOut bytes.Buffer
+	testArgs := append([]string{"test", "-json", "-p", cores, "-parallel", cores, "-vet=off", "-count=1", "-mod=mod"}, l.tagArgs()...)
+	err := l.execTo(modDir, &events, io.MultiWriter(l.logs, &testErr), "go", append(testArgs, "./...")...)
+	res.Packages, res.Tests = ParseTestEvents(&events, l.logs, &failedOut)
+	if err != nil {
+		if err == errTimeout {
+			fmt.Fprintln(l.logs, "   ⏰ Tests timed out")
+			fail(res, PhaseTest, KindTimeout, "Test timeout")
+		} else {
+			// Passing tests may print anything, so only failures are classified
+			output := failedOut.String() + testErr.String()
+			fail(res, PhaseTest, failureKind(output, err.Error(), KindTestFailure, l.noNetwork, l.memoryLimit), testFailureExcerpt(testErr.String(), res.Tests))
+			fmt.Fprintf(l.logs, "   ❌ Tests failed: %s\n", res.Error)
+		}
+		return
+	}
+
+	fmt.Fprintln(l.logs, "   ✅ Tests passed")
+	res.Passed = true
+}
+
+// pinModule checks out commit in the dependent's clone, fetching it if the
+// default branch moved on since the commit was resolved.
+func (l *localRun) pinModule(commit string) error {
+	dir := filepath.Join(l.workDir, "dependent-module")
+	if l.revParseHead(dir) == commit {
+		return nil
+	}
+	fmt.Fprintf(l.logs, "📌 Fetching pinned commit %s\n", commit)
+	if err := l.exec(dir, nil, "git", "fetch", "--depth", "1", "origin", commit); err != nil {
+		return err
+	}
+	return l.exec(dir, nil, "git", "checkout", "--detach", "FETCH_HEAD")
+}
+
+// revParseHead returns the commit checked out in dir, or "" if it can't
+// be read.
+func (l *localRun) revParseHead(dir string) string {
+	var out bytes.Buffer
+	if err := l.execTo(dir, &out, io.Discard, "git", "rev-parse", "HEAD"); err != nil {
+		return ""
+	}
+	return strings.TrimSpace(out.String())
+}
+
+// worktree is the dependent's worktree for side.
+func (l *localRun) worktree(side string) string {
+	return filepath.Join(l.workDir, "dependent-"+side)
+}
+
+// modDir is the dependent module's directory in the worktree for side.
+func (l *localRun) modDir(side string) string {
+	return filepath.Join(l.worktree(side), l.subdir)
+}
+
+func (l *localRun) tagArgs() []string {
+	if len(l.tags) == 0 {
+		return nil
+	}
+	return []string{"-tags=" + strings.Join(l.tags, ",")}
+}
+
+// errorExcerpt keeps the first few lines of a tool's error output on a
+// single line.
+func errorExcerpt(out string) string {
+	lines := strings.Split(strings.TrimSpace(out), "\n")
+	if len(lines) > 5 {
+		lines = lines[:5]
+	}
+	return strings.Join(lines, " ")
+}
+
+// testFailureExcerpt summarizes a failed `go test -json` run. Test failures
+// only show up in the event stream, so when stderr is empty the failing test
+// names are used instead.
+func testFailureExcerpt(stderr string, tests []TestOutcome) string {
+	if excerpt := errorExcerpt(stderr); excerpt != "" {
+		return excerpt
+	}
+	failed := FailedTests(tests)
+	if len(failed) == 0 {
+		return "go test failed"
+	}
+	var names []string
+	for i, t := range failed {
+		if i == 5 {
+			names = append(names, "...")
+			break
+		}
+		names = append(names, t.Test)
+	}
+	return fmt.Sprintf("%d tests failed: %s", len(failed), strings.Join(names, ", "))
+}
@@ -0,0 +1,116 @@
+package internal
+
+import (
+	"archive/zip"
+	"context"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// fileProxy writes a module proxy under dir serving example.com/up at each
+// version, where V returns the value given for it.
+func fileProxy(t *testing.T, dir string, versions map[string]string) {
+	t.Helper()
+	gomod := "module example.com/up\n\ngo 1.21\n"
+	vdir := filepath.Join(dir, "example.com", "up", "@v")
+	if err := os.MkdirAll(vdir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	var list string
+	for version, v := range versions {
+		list += version + "\n"
+		if err := os.WriteFile(filepath.Join(vdir, version+".mod"), []byte(gomod), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(vdir, version+".info"), []byte(`{"Version":"`+version+`"}`), 0644); err != nil {
+			t.Fatal(err)
+		}
+		f, err := os.Create(filepath.Join(vdir, version+".zip"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		z := zip.NewWriter(f)
+		for name, content := range map[string]string{
+			"go.mod": gomod,
+			"up.go":  "package up\n\nfunc V() int { return " + v + " }\n",
+		} {
+			w, err := z.Create("example.com/up@" + version + "/" + name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			io.WriteString(w, content)
+		}
+		if err := z.Close(); err != nil {
+			t.Fatal(err)
+		}
+		f.Close()
+	}
+	if err := os.WriteFile(filepath.Join(vdir, "list"), []byte(list), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestVersionJobWithoutUpstreamClone(t *testing.T) {
+	for _, tool := range []string{"git", "go"} {
+		if _, err := exec.LookPath(tool); err != nil {
+			t.Skipf("%s not found", tool)
+		}
+	}
+
+	proxy := t.TempDir()
+	fileProxy(t, proxy, map[string]string{"v0.1.0": "1", "v0.2.0": "2"})
+	dependent := t.TempDir()
+	gitRepo(t, dependent, map[string]string{
+		"go.mod":      "module example.com/dep\n\ngo 1.21\n\nrequire example.com/up v0.1.0\n",
+		"dep.go":      "package dep\n\nimport \"example.com/up\"\n\nfunc V() int { return up.V() }\n",
+		"dep_test.go": "package dep\n\nimport \"testing\"\n\nfunc TestV(t *testing.T) {\n\tif V() != 1 {\n\t\tt.Fatal(\"bad\")\n\t}\n}\n",
+	})
+
+	runner := LocalRunner{
+		Timeout: 2 * time.Minute,
+		Env:     []string{"GOPROXY=file://" + proxy, "GOSUMDB=off", "GOFLAGS=-mod=mod"},
+	}
+	for _, tc := range []struct {
+		name    string
+		job     Job
+		version string
+		passed  bool
+	}{
+		{"head version", Job{Head: "v0.2.0", HeadVersion: "v0.2.0", Only: "head"}, "v0.2.0", false},
+		{"pinned base", Job{Base: PinnedVersion, BaseVersion: PinnedVersion, Only: "base"}, "v0.1.0", true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			// The upstream repo can't be cloned, so the job only works if it
+			// uses the module path resolved on the host
+			job := tc.job
+			job.Module = "example.com/dep"
+			job.Repo = "file://" + filepath.Join(t.TempDir(), "missing.git")
+			job.CloneURL = "file://" + dependent
+			job.UpstreamModule = "example.com/up"
+			job = agentJob(t, job)
+
+			r, err := runner.Run(context.Background(), job, io.Discard)
+			if err != nil {
+				t.Fatal(err)
+			}
+			res := r.Head
+			if job.Only == "base" {
+				res = r.Base
+			}
+			switch {
+			case r.Error != "" || res.Skipped:
+				t.Fatalf("%s was skipped: %s%s", job.Only, r.Error, res.Error)
+			case res.Version != tc.version:
+				t.Fatalf("%s version = %q, want %s: %s", job.Only, res.Version, tc.version, res.Error)
+			case res.Passed != tc.passed:
+				t.Fatalf("%s passed = %v, want %v: %s", job.Only, res.Passed, tc.passed, res.Error)
+			case !tc.passed && !res.RealFailure():
+				t.Fatalf("%s failure isn't a real failure: %s %s", job.Only, res.Kind, res.Error)
+			}
+		})
+	}
+}
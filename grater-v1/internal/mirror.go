@@ -0,0 +1,276 @@
+package internal
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// Offline runs are served by two local stand-ins for the network: a module
+// proxy laid out for a file:// GOPROXY, and a directory of bare git mirrors
+// at <dir>/<host>/<path>.git. grater mirror sync fills both.
+
+// OfflineEnv is the environment of an offline job: modules only come from
+// the proxy at goproxy, nothing is checked against the checksum database,
+// and git refuses anything but local repos.
+func OfflineEnv(goproxy string) []string {
+	return []string{"GOPROXY=" + goproxy, "GOSUMDB=off", "GIT_ALLOW_PROTOCOL=file"}
+}
+
+// FileProxyDir returns the directory of a file:// GOPROXY, or "" for any
+// other proxy.
+func FileProxyDir(goproxy string) string {
+	if !strings.HasPrefix(goproxy, "file://") {
+		return ""
+	}
+	return filepath.Clean(strings.TrimPrefix(goproxy, "file://"))
+}
+
+// mirrorURL is the URL url is cloned from when dir holds git mirrors, and
+// url itself otherwise.
+func mirrorURL(dir, url string) string {
+	if dir == "" {
+		return url
+	}
+	return "file://" + mirrorPath(dir, url)
+}
+
+// mirrorPath is the bare mirror of url in dir. HTTPS and SSH URLs of the
+// same repo share a mirror.
+func mirrorPath(dir, url string) string {
+	return filepath.Join(dir, cleanRepoURL(strings.TrimPrefix(url, "file://"))) + ".git"
+}
+
+// SyncGitMirror clones url into its mirror in dir, or fetches into the
+// existing mirror.
+func SyncGitMirror(dir, url string) error {
+	path := mirrorPath(dir, url)
+	if _, err := os.Stat(path); err == nil {
+		// Point the mirror at url in case the repo moved
+		if out, err := exec.Command("git", "--git-dir", path, "remote", "set-url", "origin", url).CombinedOutput(); err != nil {
+			return fmt.Errorf("failed to update mirror of %s: %v: %s", Redact(url), err, strings.TrimSpace(string(out)))
+		}
+		if out, err := exec.Command("git", "--git-dir", path, "remote", "update", "--prune").CombinedOutput(); err != nil {
+			return fmt.Errorf("failed to update mirror of %s: %v: %s", Redact(url), err, strings.TrimSpace(string(out)))
+		}
+		return nil
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	if out, err := exec.Command("git", "clone", "--mirror", "--quiet", url, path).CombinedOutput(); err != nil {
+		os.RemoveAll(path)
+		return fmt.Errorf("failed to mirror %s: %v: %s", Redact(url), err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// MirrorModule mirrors the repo at url into gitDir and downloads what module
+// needs at each of refs into modCache, or at the tip of the default branch
+// if refs is empty. module may live in a subdirectory of the repo; "" means
+// the module at the repo root.
+//
+// Dependents are tested against published versions of the upstream module
+// too, so if upstream is set, what module needs with each of versions of
+// upstream is downloaded as well.
+func MirrorModule(gitDir, modCache, url, module string, refs []string, upstream string, versions []string, env []string) error {
+	url = repoCloneURL(url)
+	if err := SyncGitMirror(gitDir, url); err != nil {
+		return err
+	}
+
+	work, err := os.MkdirTemp("", "grater-mirror-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(work)
+	// The tip of the default branch is all a shallow clone needs
+	args := []string{"clone", "--quiet", "--no-checkout"}
+	if len(refs) == 0 {
+		args = append(args, "--depth", "1")
+		refs = []string{"HEAD"}
+	}
+	if out, err := exec.Command("git", append(args, mirrorURL(gitDir, url), work)...).CombinedOutput(); err != nil {
+		return fmt.Errorf("failed to check out mirror of %s: %v: %s", Redact(url), err, strings.TrimSpace(string(out)))
+	}
+	for _, ref := range refs {
+		if out, err := exec.Command("git", "-C", work, "checkout", "--quiet", "--force", ref, "--").CombinedOutput(); err != nil {
+			return fmt.Errorf("failed to check out %s of %s: %v: %s", ref, Redact(url), err, strings.TrimSpace(string(out)))
+		}
+		dir := work
+		if module != "" {
+			dir = filepath.Join(work, findModuleDir(work, module))
+		}
+		if err := DownloadModules(dir, modCache, env); err != nil {
+			return err
+		}
+		for _, version := range versions {
+			if err := downloadWithVersion(dir, modCache, upstream, version, env); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// downloadWithVersion downloads what the dependent in dir needs once its
+// requirement on upstream is moved to version, the way a job does it with
+// `go get`. The dependent's go.mod is restored afterwards. Dependents that
+// don't require upstream, and PinnedVersion, need nothing more.
+func downloadWithVersion(dir, modCache, upstream, version string, env []string) error {
+	if version == PinnedVersion {
+		return nil
+	}
+	if _, err := requiredRootModule(dir, upstream); err != nil {
+		return nil
+	}
+	defer exec.Command("git", "-C", dir, "checkout", "--quiet", "--", ".").Run()
+
+	cmd := exec.Command("go", "get", upstream+"@"+version)
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), env...)
+	cmd.Env = append(cmd.Env, "GOMODCACHE="+modCache, "GOFLAGS=-mod=mod")
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("go get %s@%s failed: %v: %s", upstream, version, err, strings.TrimSpace(string(out)))
+	}
+	return DownloadModules(dir, modCache, env)
+}
+
+// mirrorIndexFile maps module paths to the repos mirrored for them, so an
+// offline run finds the repo of a vanity import path without a lookup.
+const mirrorIndexFile = "modules.json"
+
+// LoadMirrorIndex reads the module to repo URL index of the git mirrors in
+// dir. A missing index is empty.
+func LoadMirrorIndex(dir string) (map[string]string, error) {
+	index := make(map[string]string)
+	data, err := os.ReadFile(filepath.Join(dir, mirrorIndexFile))
+	if os.IsNotExist(err) {
+		return index, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &index); err != nil {
+		return nil, fmt.Errorf("failed to parse mirror index: %w", err)
+	}
+	return index, nil
+}
+
+// SaveMirrorIndex writes the module to repo URL index of the git mirrors in
+// dir.
+func SaveMirrorIndex(dir string, index map[string]string) error {
+	data, err := json.MarshalIndent(index, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(dir, mirrorIndexFile), data, 0644)
+}
+
+// DownloadModules downloads everything the module in dir needs to build and
+// test into modCache, using env on top of the host environment.
+func DownloadModules(dir, modCache string, env []string) error {
+	cmd := exec.Command("go", "mod", "download")
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), env...)
+	cmd.Env = append(cmd.Env, "GOMODCACHE="+modCache, "GOFLAGS=-mod=mod")
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("go mod download failed: %v: %s", err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// FillModuleProxy copies the downloads in modCache into the file proxy in
+// proxyDir. The module cache keeps downloads in the proxy layout, so files
+// are copied as is, except that version lists are merged.
+func FillModuleProxy(proxyDir, modCache string) error {
+	downloads := filepath.Join(modCache, "cache", "download")
+	if _, err := os.Stat(downloads); os.IsNotExist(err) {
+		return nil
+	}
+	return filepath.WalkDir(downloads, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, _ := filepath.Rel(downloads, path)
+		if d.IsDir() {
+			// Checksum database tiles aren't part of the proxy
+			if rel == "sumdb" {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		// Only the files of the proxy protocol; the rest is cache bookkeeping
+		switch filepath.Ext(rel) {
+		case ".info", ".mod", ".zip":
+		default:
+			if d.Name() != "list" {
+				return nil
+			}
+		}
+
+		dst := filepath.Join(proxyDir, rel)
+		if d.Name() == "list" {
+			return mergeVersionList(dst, path)
+		}
+		// Downloads of a version never change
+		if _, err := os.Stat(dst); err == nil {
+			return nil
+		}
+		return copyFile(dst, path)
+	})
+}
+
+// mergeVersionList adds the versions listed in src to the list at dst.
+func mergeVersionList(dst, src string) error {
+	var versions []string
+	for _, file := range []string{dst, src} {
+		data, err := os.ReadFile(file)
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		versions = append(versions, strings.Fields(string(data))...)
+	}
+	slices.Sort(versions)
+	versions = slices.Compact(versions)
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(dst, []byte(strings.Join(versions, "\n")+"\n"), 0644)
+}
+
+// copyFile copies src to dst through a temp file, so an interrupted sync
+// never leaves a truncated download in the proxy.
+func copyFile(dst, src string) error {
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return err
+	}
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	tmp, err := os.CreateTemp(filepath.Dir(dst), ".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := io.Copy(tmp, in); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), dst)
+}
@@ -0,0 +1,87 @@
+package internal
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+)
+
+func TestMirrorModuleFillsRefsAndVersions(t *testing.T) {
+	for _, tool := range []string{"git", "go"} {
+		if _, err := exec.LookPath(tool); err != nil {
+			t.Skipf("%s not found", tool)
+		}
+	}
+
+	proxy := t.TempDir()
+	fileProxy(t, proxy, map[string]string{"v0.1.0": "1", "v0.2.0": "2"})
+	env := []string{"GOPROXY=file://" + proxy, "GOSUMDB=off"}
+
+	// Only the feature branch of the repo needs example.com/up
+	repo := t.TempDir()
+	gitRepo(t, repo, map[string]string{"go.mod": "module example.com/repo\n\ngo 1.21\n"})
+	for _, args := range [][]string{
+		{"checkout", "--quiet", "-b", "feature"},
+		{"-c", "user.name=test", "-c", "user.email=test@example.com", "commit", "--quiet", "--allow-empty", "-m", "feature"},
+	} {
+		cmd := exec.Command("git", args...)
+		cmd.Dir = repo
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("git %v: %v\n%s", args, err, out)
+		}
+	}
+	if err := os.WriteFile(filepath.Join(repo, "go.mod"), []byte("module example.com/repo\n\ngo 1.21\n\nrequire example.com/up v0.1.0\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	cmd := exec.Command("git", "-c", "user.name=test", "-c", "user.email=test@example.com", "commit", "--quiet", "-am", "require up")
+	cmd.Dir = repo
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("git commit: %v\n%s", err, out)
+	}
+	if out, err := exec.Command("git", "-C", repo, "checkout", "--quiet", "-").CombinedOutput(); err != nil {
+		t.Fatalf("git checkout: %v\n%s", err, out)
+	}
+
+	dependent := t.TempDir()
+	gitRepo(t, dependent, map[string]string{
+		"go.mod": "module example.com/dep\n\ngo 1.21\n\nrequire example.com/up v0.1.0\n",
+		"dep.go": "package dep\n\nimport _ \"example.com/up\"\n",
+	})
+
+	downloaded := func(modCache, version string) bool {
+		_, err := os.Stat(filepath.Join(modCache, "cache", "download", "example.com", "up", "@v", version+".zip"))
+		return err == nil
+	}
+	for _, tc := range []struct {
+		name     string
+		url      string
+		refs     []string
+		versions []string
+		want     string
+	}{
+		{"ref", "file://" + repo, []string{"feature"}, nil, "v0.1.0"},
+		{"version", "file://" + dependent, nil, []string{PinnedVersion, "v0.2.0"}, "v0.2.0"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			modCache := t.TempDir()
+			defer RemoveModCache(modCache)
+			if err := MirrorModule(t.TempDir(), modCache, tc.url, "", tc.refs, "example.com/up", tc.versions, env); err != nil {
+				t.Fatal(err)
+			}
+			if !downloaded(modCache, tc.want) {
+				t.Fatalf("example.com/up %s wasn't downloaded", tc.want)
+			}
+		})
+	}
+
+	// Without refs only the default branch is synced
+	modCache := t.TempDir()
+	defer RemoveModCache(modCache)
+	if err := MirrorModule(t.TempDir(), modCache, "file://"+repo, "", nil, "", nil, env); err != nil {
+		t.Fatal(err)
+	}
+	if downloaded(modCache, "v0.1.0") {
+		t.Fatal("modules of the feature branch were downloaded without --ref")
+	}
+}
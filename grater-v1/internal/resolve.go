@@ -0,0 +1,269 @@
+package internal
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+// ModuleSource is where the code of a module lives: the repo holding it and
+// the module's directory inside that repo.
+type ModuleSource struct {
+	Module  string `json:"module"`
+	Root    string `json:"root"` // import path of the repo root
+	RepoURL string `json:"repo_url"`
+	Subdir  string `json:"subdir,omitempty"`
+}
+
+// CloneURL is the URL to clone the source from, over SSH
+// (git@host:path.git) if ssh is set.
+func (s ModuleSource) CloneURL(ssh bool) string {
+	if !ssh {
+		return s.RepoURL
+	}
+	host, p, _ := strings.Cut(cleanRepoURL(s.RepoURL), "/")
+	return "git@" + host + ":" + p + ".git"
+}
+
+// knownHosts are code hosts whose repo root is always the first three path
+// elements, so they need no lookup.
+var knownHosts = []string{"github.com", "bitbucket.org"}
+
+// ResolveModule finds the repo of a module or package path the way the go
+// command does. Known hosts are mapped directly. Otherwise the module proxy
+// is asked for the origin of the module's latest version, which also knows
+// the module's subdirectory, and failing that the go-import meta tag served
+// at https://<path>?go-get=1 is used.
+func ResolveModule(module string) (ModuleSource, error) {
+	parts := strings.Split(module, "/")
+	for _, host := range knownHosts {
+		if parts[0] != host || len(parts) < 3 {
+			continue
+		}
+		root := strings.Join(parts[:3], "/")
+		return ModuleSource{
+			Module:  module,
+			Root:    root,
+			RepoURL: "https://" + root + ".git",
+			Subdir:  moduleSubdir(module, root),
+		}, nil
+	}
+
+	if src, err := resolveFromProxy(module); err == nil {
+		return src, nil
+	}
+	return resolveFromMeta(module)
+}
+
+// ModuleOfPackage returns the path of the module providing the package at
+// pkg: like the go command, the longest prefix of pkg the module proxy knows
+// as a module. It returns "" if there is no proxy for pkg or none is known.
+func ModuleOfPackage(pkg string) string {
+	proxy := moduleProxy(pkg)
+	if proxy == "" {
+		return ""
+	}
+	for p := pkg; strings.Contains(p, "/"); p = path.Dir(p) {
+		resp, err := httpClient.Get(proxy + "/" + escapeModulePath(p) + "/@latest")
+		if err != nil {
+			return ""
+		}
+		resp.Body.Close()
+		if resp.StatusCode == http.StatusOK {
+			return p
+		}
+	}
+	return ""
+}
+
+// RepoRootModule returns the path of the module at the root of repo, as
+// declared by its go.mod at version, which is read from the module proxies
+// in goproxy instead of a clone. Modules at major version 2 or later are
+// looked up under their /vN path first.
+func RepoRootModule(goproxy, repo, version string) (string, error) {
+	base := repoModulePath(repo)
+	candidates := []string{base}
+	if major, _, _ := strings.Cut(strings.TrimPrefix(version, "v"), "."); major != "0" && major != "1" {
+		candidates = []string{base + "/v" + major, base}
+	}
+	err := fmt.Errorf("no module proxy in GOPROXY=%s", goproxy)
+	for _, proxy := range strings.FieldsFunc(goproxy, func(r rune) bool { return r == ',' || r == '|' }) {
+		for _, module := range candidates {
+			var data []byte
+			if data, err = proxyModFile(proxy, module, version); err != nil {
+				continue
+			}
+			if path := parseModulePath(data); path != "" {
+				return path, nil
+			}
+			err = fmt.Errorf("no module directive in %s@%s's go.mod", module, version)
+		}
+	}
+	return "", err
+}
+
+// proxyModFile reads the go.mod of module at version from an HTTP or
+// file:// module proxy.
+func proxyModFile(proxy, module, version string) ([]byte, error) {
+	file := escapeModulePath(module) + "/@v/" + escapeModulePath(version) + ".mod"
+	if dir := FileProxyDir(proxy); dir != "" {
+		return os.ReadFile(filepath.Join(dir, filepath.FromSlash(file)))
+	}
+	if !strings.HasPrefix(proxy, "https://") && !strings.HasPrefix(proxy, "http://") {
+		return nil, fmt.Errorf("can't read modules from GOPROXY entry %q", proxy)
+	}
+	resp, err := httpClient.Get(strings.TrimSuffix(proxy, "/") + "/" + file)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("proxy returned %s for %s@%s", resp.Status, module, version)
+	}
+	return io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+}
+
+// moduleSubdir guesses the directory of module inside the repo at root. A
+// major version suffix is usually a branch, not a directory, so it is left
+// out.
+func moduleSubdir(module, root string) string {
+	sub := strings.Trim(strings.TrimPrefix(module, root), "/")
+	return strings.Trim(majorSuffix.ReplaceAllString("/"+sub, ""), "/")
+}
+
+// proxyOrigin is the part of a proxy's .info response grater uses.
+type proxyOrigin struct {
+	Origin *struct {
+		VCS    string
+		URL    string
+		Subdir string
+	}
+}
+
+func resolveFromProxy(module string) (ModuleSource, error) {
+	proxy := moduleProxy(module)
+	if proxy == "" {
+		return ModuleSource{}, fmt.Errorf("no module proxy for %s", module)
+	}
+	resp, err := httpClient.Get(proxy + "/" + escapeModulePath(module) + "/@latest")
+	if err != nil {
+		return ModuleSource{}, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return ModuleSource{}, fmt.Errorf("proxy returned %s for %s", resp.Status, module)
+	}
+	var info proxyOrigin
+	if err := json.NewDecoder(resp.Body).Decode(&info); err != nil {
+		return ModuleSource{}, err
+	}
+	if info.Origin == nil || info.Origin.VCS != "git" || info.Origin.URL == "" {
+		return ModuleSource{}, fmt.Errorf("proxy has no git origin for %s", module)
+	}
+	root := majorSuffix.ReplaceAllString(module, "")
+	if info.Origin.Subdir != "" {
+		root = strings.TrimSuffix(module, "/"+info.Origin.Subdir)
+	}
+	return ModuleSource{
+		Module:  module,
+		Root:    root,
+		RepoURL: info.Origin.URL,
+		Subdir:  info.Origin.Subdir,
+	}, nil
+}
+
+// moduleProxy returns the first HTTP proxy in GOPROXY, unless module is
+// private per GOPRIVATE or GONOPROXY.
+func moduleProxy(module string) string {
+	noProxy := os.Getenv("GONOPROXY")
+	if noProxy == "" {
+		noProxy = os.Getenv("GOPRIVATE")
+	}
+	if matchPathPrefix(noProxy, module) {
+		return ""
+	}
+	proxies := os.Getenv("GOPROXY")
+	if proxies == "" {
+		proxies = "https://proxy.golang.org"
+	}
+	for _, p := range strings.FieldsFunc(proxies, func(r rune) bool { return r == ',' || r == '|' }) {
+		if strings.HasPrefix(p, "https://") || strings.HasPrefix(p, "http://") {
+			return strings.TrimSuffix(p, "/")
+		}
+	}
+	return ""
+}
+
+// matchPathPrefix reports whether a prefix of path matches one of the
+// comma-separated glob patterns, as GOPRIVATE does.
+func matchPathPrefix(patterns, p string) bool {
+	for _, pattern := range strings.Split(patterns, ",") {
+		pattern = strings.TrimSpace(pattern)
+		if pattern == "" {
+			continue
+		}
+		n := strings.Count(pattern, "/") + 1
+		elems := strings.SplitN(p, "/", n+1)
+		if len(elems) < n {
+			continue
+		}
+		if ok, _ := path.Match(pattern, strings.Join(elems[:n], "/")); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// escapeModulePath escapes upper case letters for the proxy protocol, e.g.
+// github.com/Foo -> github.com/!foo.
+func escapeModulePath(p string) string {
+	var b strings.Builder
+	for _, r := range p {
+		if unicode.IsUpper(r) {
+			b.WriteByte('!')
+			r = unicode.ToLower(r)
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+var goImportMeta = regexp.MustCompile(`<meta\s+name=["']go-import["']\s+content=["']([^"']+)["']`)
+
+func resolveFromMeta(module string) (ModuleSource, error) {
+	resp, err := httpClient.Get("https://" + module + "?go-get=1")
+	if err != nil {
+		return ModuleSource{}, fmt.Errorf("failed to resolve %s: %w", module, err)
+	}
+	defer resp.Body.Close()
+	// The meta tags are in the head; don't read a whole page
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return ModuleSource{}, fmt.Errorf("failed to resolve %s: %w", module, err)
+	}
+
+	for _, m := range goImportMeta.FindAllStringSubmatch(string(body), -1) {
+		fields := strings.Fields(m[1])
+		if len(fields) != 3 || fields[1] != "git" {
+			continue
+		}
+		prefix, url := fields[0], fields[2]
+		if module != prefix && !strings.HasPrefix(module, prefix+"/") {
+			continue
+		}
+		return ModuleSource{
+			Module:  module,
+			Root:    prefix,
+			RepoURL: url,
+			Subdir:  moduleSubdir(module, prefix),
+		}, nil
+	}
+	return ModuleSource{}, fmt.Errorf("failed to resolve %s: no git go-import meta tag", module)
+}
@@ -0,0 +1,53 @@
+package internal
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestModuleOfPackage(t *testing.T) {
+	known := map[string]bool{"example.com/repo": true, "example.com/repo/sub": true}
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		module, ok := strings.CutSuffix(strings.TrimPrefix(r.URL.Path, "/"), "/@latest")
+		if !ok || !known[module] {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte(`{"Version":"v1.0.0"}`))
+	}))
+	defer proxy.Close()
+	t.Setenv("GOPROXY", proxy.URL)
+	t.Setenv("GOPRIVATE", "")
+	t.Setenv("GONOPROXY", "")
+
+	for pkg, want := range map[string]string{
+		"example.com/repo":         "example.com/repo",
+		"example.com/repo/pkg/x":   "example.com/repo",
+		"example.com/repo/sub":     "example.com/repo/sub",
+		"example.com/repo/sub/pkg": "example.com/repo/sub",
+		"example.com/unknown/pkg":  "",
+	} {
+		if got := ModuleOfPackage(pkg); got != want {
+			t.Errorf("ModuleOfPackage(%s) = %q, want %q", pkg, got, want)
+		}
+	}
+}
+
+func TestRepoRootModule(t *testing.T) {
+	dir := t.TempDir()
+	fileProxy(t, dir, map[string]string{"v0.2.0": "2"})
+	proxy := httptest.NewServer(http.FileServer(http.Dir(dir)))
+	defer proxy.Close()
+
+	for _, goproxy := range []string{"file://" + dir, proxy.URL, "off," + proxy.URL} {
+		got, err := RepoRootModule(goproxy, "https://example.com/up.git", "v0.2.0")
+		if err != nil || got != "example.com/up" {
+			t.Errorf("RepoRootModule(%s) = %q, %v; want example.com/up", goproxy, got, err)
+		}
+	}
+	if _, err := RepoRootModule("file://"+dir, "https://example.com/up.git", "v0.3.0"); err == nil {
+		t.Error("found a module at a version the proxy doesn't have")
+	}
+}
@@ -0,0 +1,261 @@
+package internal
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os/exec"
+	"strings"
+)
+
+// RefResult is the outcome of testing a dependent against one upstream ref.
+type RefResult struct {
+	Ref     string `json:"ref"`
+	Commit  string `json:"commit,omitempty"`
+	Passed  bool   `json:"passed"`
+	Error   string `json:"error"`
+	Skipped bool   `json:"skipped"`
+
+	// Phase and Kind classify a failure: the step that failed (clone,
+	// fetch, checkout, replace, download, build, vet, test) and why
+	// (timeout, infra, compile, test-failure, panic, oom, network).
+	Phase string `json:"phase,omitempty"`
+	Kind  string `json:"kind,omitempty"`
+
+	// Replacements lists the upstream module paths that were replaced with
+	// the checkout of this ref in the dependent's go.mod.
+	Replacements []string `json:"replacements,omitempty"`
+
+	// Version is the version of the upstream module that `go get` resolved
+	// when the ref is a published version, and Sum and GoModSum are its
+	// go.sum hashes.
+	Version  string `json:"version,omitempty"`
+	Sum      string `json:"sum,omitempty"`
+	GoModSum string `json:"go_mod_sum,omitempty"`
+
+	// Log is the path of the full log of this ref: git, go build and go
+	// test output.
+	Log string `json:"log,omitempty"`
+
+	// Packages and Tests hold the final `go test -json` outcome of every
+	// package and test run against this ref.
+	Packages []TestOutcome `json:"packages,omitempty"`
+	Tests    []TestOutcome `json:"tests,omitempty"`
+
+	// Reruns records extra attempts made with --retries when base and head
+	// disagreed and this ref was the failing side.
+	Reruns []RefResult `json:"reruns,omitempty"`
+
+	// Cached is set when the result was reused from an earlier run instead
+	// of being tested again.
+	Cached bool `json:"cached,omitempty"`
+}
+
+// DualResult matches the detailed structure produced by every Runner.
+type DualResult struct {
+	Module string    `json:"module"`
+	Repo   string    `json:"repo,omitempty"`
+	Base   RefResult `json:"base"`
+	Head   RefResult `json:"head"`
+
+	// Error is set when the module couldn't be compared at all, e.g. when
+	// the dependent doesn't require the upstream module. Such modules are
+	// reported as ERROR.
+	Error string `json:"error,omitempty"`
+
+	// ModuleCommit is the commit of the dependent that was tested.
+	ModuleCommit string `json:"module_commit,omitempty"`
+
+	// Refs holds the result of every ref of a job that tests a list of
+	// refs, in order. Base and Head are then the first and last of them.
+	Refs []RefResult `json:"refs,omitempty"`
+}
+
+// Job describes a single dependent module to test against base and head.
+type Job struct {
+	Module string
+	Repo   string
+	Base   string
+	Head   string
+
+	// ModuleCommit, when set, pins the dependent to this commit instead of
+	// the tip of its default branch.
+	ModuleCommit string
+
+	// CloneURL, when set, is where the dependent is cloned from, e.g. the
+	// repo a vanity import path resolves to. Otherwise it is derived from
+	// Module.
+	CloneURL string
+
+	// CloneSSH clones the dependent over SSH instead of anonymous HTTPS when
+	// CloneURL isn't set.
+	CloneSSH bool
+
+	// GitMirror, when set, is a directory of bare git mirrors that the
+	// dependency repo and the dependent are cloned from instead of their
+	// remotes.
+	GitMirror string
+
+	// BaseDir and HeadDir, when set, are host directories holding a checkout
+	// of the upstream repo to use instead of fetching the ref.
+	BaseDir string
+	HeadDir string
+
+	// BaseVersion and HeadVersion, when set, are published versions of the
+	// upstream module to `go get` in the dependent instead of replacing it
+	// with a checkout of the ref. PinnedVersion keeps the version the
+	// dependent requires.
+	BaseVersion string
+	HeadVersion string
+
+	// UpstreamModule is the path of the module at the root of the upstream
+	// repo, which version refs look up in the dependent's go.mod. Empty
+	// means it is read from a clone of the repo.
+	UpstreamModule string
+
+	// LogDir, when set, is the directory where base.log and head.log are
+	// written.
+	LogDir string
+
+	// CPUs and Memory cap the resources of the job's container, in docker's
+	// --cpus and --memory syntax. Empty means no limit.
+	CPUs   string
+	Memory string
+
+	// Only restricts the run to a single ref, "base" or "head". The other
+	// ref in the result is left untested.
+	Only string
+
+	// Refs, when set, lists every ref to test in order. Base and Head must
+	// then be the first and last of them. RefDirs optionally holds a local
+	// checkout for each ref, like BaseDir and HeadDir.
+	Refs    []string
+	RefDirs []string
+}
+
+// PinnedVersion as a job version tests the dependent against the version of
+// the upstream module it already requires, without changing its go.mod.
+const PinnedVersion = "pinned"
+
+// jobRef is one ref a job tests and where its result goes.
+type jobRef struct {
+	name    string // base, head or ref-N; names its log file and worktrees
+	srcDir  string
+	version string
+	res     *RefResult
+}
+
+// newResult returns the result of job before anything was tested.
+func newResult(job Job) DualResult {
+	r := DualResult{Module: job.Module, Repo: job.Repo}
+	r.Base.Ref = job.Base
+	r.Head.Ref = job.Head
+	for _, ref := range job.Refs {
+		r.Refs = append(r.Refs, RefResult{Ref: ref})
+	}
+	return r
+}
+
+// SkippedResult is the result of job when it couldn't run: every ref,
+// including each of a multi-ref job, is skipped with reason and kind.
+// Credentials are redacted.
+func SkippedResult(job Job, kind, reason string) DualResult {
+	r := failBoth(newResult(job), "", kind, reason)
+	r.redact()
+	return r
+}
+
+// refs lists the refs job tests, honouring Only, with their results in r.
+func (j Job) refs(r *DualResult) []jobRef {
+	if len(j.Refs) > 0 {
+		var refs []jobRef
+		for i := range r.Refs {
+			var dir string
+			if i < len(j.RefDirs) {
+				dir = j.RefDirs[i]
+			}
+			refs = append(refs, jobRef{name: fmt.Sprintf("ref-%d", i+1), srcDir: dir, res: &r.Refs[i]})
+		}
+		return refs
+	}
+
+	var refs []jobRef
+	if j.Only != "head" {
+		refs = append(refs, jobRef{name: "base", srcDir: j.BaseDir, version: j.BaseVersion, res: &r.Base})
+	}
+	if j.Only != "base" {
+		refs = append(refs, jobRef{name: "head", srcDir: j.HeadDir, version: j.HeadVersion, res: &r.Head})
+	}
+	return refs
+}
+
+// all returns every ref result in r.
+func (r *DualResult) all() []*RefResult {
+	all := []*RefResult{&r.Base, &r.Head}
+	for i := range r.Refs {
+		all = append(all, &r.Refs[i])
+	}
+	return all
+}
+
+// syncEnds copies the first and last of Refs into Base and Head.
+func (r *DualResult) syncEnds() {
+	if len(r.Refs) > 0 {
+		r.Base, r.Head = r.Refs[0], r.Refs[len(r.Refs)-1]
+	}
+}
+
+// Runner tests a dependent module against the base and head refs of the
+// upstream repo. Progress and tool output are written to logs. When ctx is
+// cancelled the job is stopped and Run returns ctx's error.
+type Runner interface {
+	Run(ctx context.Context, job Job, logs io.Writer) (DualResult, error)
+}
+
+// repoModulePath turns a repo URL into the module path used for replace
+// directives, e.g. https://github.com/foo/bar.git -> github.com/foo/bar.
+func repoModulePath(repo string) string {
+	return strings.TrimPrefix(cleanRepoURL(repo), "www.")
+}
+
+// repoCloneURL turns a repo URL or module path into something git can clone.
+func repoCloneURL(repo string) string {
+	// Local and SSH repos are cloned as is
+	if strings.HasPrefix(repo, "file://") || strings.HasPrefix(repo, "ssh://") || strings.HasPrefix(repo, "git@") {
+		return repo
+	}
+	url := strings.TrimSuffix(repo, ".git")
+	if !strings.HasPrefix(url, "http://") && !strings.HasPrefix(url, "https://") {
+		url = "https://" + url
+	}
+	return url + ".git"
+}
+
+// moduleCloneURL is the URL the dependent of job is cloned from.
+func (j Job) moduleCloneURL() string {
+	url := j.CloneURL
+	if url == "" {
+		url = ModuleSource{RepoURL: "https://" + j.Module + ".git"}.CloneURL(j.CloneSSH)
+	}
+	return mirrorURL(j.GitMirror, url)
+}
+
+// repoCloneURL is the URL the dependency repo of job is cloned from.
+func (j Job) repoCloneURL() string {
+	return mirrorURL(j.GitMirror, repoCloneURL(j.Repo))
+}
+
+// ResolveModuleCommit returns the commit at the tip of the default branch
+// of job's dependent.
+func ResolveModuleCommit(job Job) (string, error) {
+	module := job.Module
+	out, err := exec.Command("git", "ls-remote", job.moduleCloneURL(), "HEAD").Output()
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve HEAD of %s: %w", module, err)
+	}
+	sha, _, _ := strings.Cut(strings.TrimSpace(string(out)), "\t")
+	if sha == "" {
+		return "", fmt.Errorf("failed to resolve HEAD of %s: no HEAD ref", module)
+	}
+	return sha, nil
+}
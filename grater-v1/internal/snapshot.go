@@ -0,0 +1,83 @@
+package internal
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// SnapshotWorkTree copies the working tree of the git checkout at src into
+// dst, including uncommitted changes and untracked files that are not
+// ignored. The .git and .grater directories are left out. dst is replaced if
+// it already exists.
+func SnapshotWorkTree(src, dst string) error {
+	out, err := exec.Command("git", "-C", src, "ls-files", "-z", "--cached", "--others", "--exclude-standard").Output()
+	if err != nil {
+		return fmt.Errorf("failed to list files in %s (is it a git checkout?): %w", src, err)
+	}
+
+	if err := os.RemoveAll(dst); err != nil {
+		return fmt.Errorf("failed to remove old snapshot %s: %w", dst, err)
+	}
+	if err := os.MkdirAll(dst, 0755); err != nil {
+		return fmt.Errorf("failed to create snapshot dir %s: %w", dst, err)
+	}
+
+	for _, name := range bytes.Split(out, []byte{0}) {
+		rel := string(name)
+		if rel == "" || rel == ".grater" || strings.HasPrefix(rel, ".grater/") {
+			continue
+		}
+		if err := copyEntry(filepath.Join(src, rel), filepath.Join(dst, rel)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func copyEntry(src, dst string) error {
+	info, err := os.Lstat(src)
+	if os.IsNotExist(err) {
+		// Tracked but deleted in the working tree
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return err
+	}
+
+	switch {
+	case info.Mode()&os.ModeSymlink != 0:
+		target, err := os.Readlink(src)
+		if err != nil {
+			return err
+		}
+		return os.Symlink(target, dst)
+	case info.IsDir():
+		// Submodules show up as directories; their contents aren't listed
+		return os.MkdirAll(dst, 0755)
+	}
+
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dst, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, info.Mode().Perm())
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return fmt.Errorf("failed to copy %s: %w", src, err)
+	}
+	return out.Close()
+}
@@ -0,0 +1,110 @@
+package internal
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io"
+	"sort"
+)
+
+// TestOutcome is the final action go test reported for a package or a test.
+type TestOutcome struct {
+	Package string `json:"package"`
+	Test    string `json:"test,omitempty"`
+	Action  string `json:"action"` // pass, fail, skip
+}
+
+// testEvent is a single line of `go test -json` output.
+type testEvent struct {
+	Action     string
+	Package    string
+	Test       string
+	Output     string
+	ImportPath string // of build-output events
+}
+
+// ParseTestEvents reads a `go test -json` stream and returns the final
+// outcome of every package and test. Lines that are not JSON events (e.g.
+// build errors from older toolchains) are ignored. Output events are copied
+// to output when it is non-nil, so the stream can still be read as plain text.
+// failedOutput, when non-nil, receives only the output of tests and packages
+// that failed, and build output, since -json makes go test print the output
+// of passing tests too.
+func ParseTestEvents(r io.Reader, output, failedOutput io.Writer) (packages, tests []TestOutcome) {
+	pkgs := make(map[string]TestOutcome)
+	byTest := make(map[string]TestOutcome)
+	outputs := make(map[string]*bytes.Buffer)
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		var ev testEvent
+		if err := json.Unmarshal(scanner.Bytes(), &ev); err != nil {
+			continue
+		}
+		switch ev.Action {
+		case "output", "build-output":
+			if output != nil {
+				io.WriteString(output, ev.Output)
+			}
+			if failedOutput == nil {
+				break
+			}
+			// Build output only exists when a build failed
+			if ev.Action == "build-output" {
+				io.WriteString(failedOutput, ev.Output)
+				break
+			}
+			key := ev.Package + "\x00" + ev.Test
+			if outputs[key] == nil {
+				outputs[key] = new(bytes.Buffer)
+			}
+			outputs[key].WriteString(ev.Output)
+		case "pass", "fail", "skip":
+			outcome := TestOutcome{Package: ev.Package, Test: ev.Test, Action: ev.Action}
+			if ev.Test == "" {
+				pkgs[ev.Package] = outcome
+			} else {
+				byTest[ev.Package+"\x00"+ev.Test] = outcome
+			}
+		}
+	}
+
+	for _, o := range pkgs {
+		packages = append(packages, o)
+	}
+	for _, o := range byTest {
+		tests = append(tests, o)
+	}
+	sortOutcomes(packages)
+	sortOutcomes(tests)
+	if failedOutput != nil {
+		for _, o := range append(FailedTests(tests), FailedTests(packages)...) {
+			if out := outputs[o.Package+"\x00"+o.Test]; out != nil {
+				failedOutput.Write(out.Bytes())
+			}
+		}
+	}
+	return packages, tests
+}
+
+// FailedTests returns the tests in outcomes that failed.
+func FailedTests(outcomes []TestOutcome) []TestOutcome {
+	var failed []TestOutcome
+	for _, o := range outcomes {
+		if o.Action == "fail" {
+			failed = append(failed, o)
+		}
+	}
+	return failed
+}
+
+func sortOutcomes(outcomes []TestOutcome) {
+	sort.Slice(outcomes, func(i, j int) bool {
+		if outcomes[i].Package != outcomes[j].Package {
+			return outcomes[i].Package < outcomes[j].Package
+		}
+		return outcomes[i].Test < outcomes[j].Test
+	})
+}
@@ -0,0 +1,33 @@
+package internal
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestParseTestEventsFailedOutput(t *testing.T) {
+	stream := strings.Join([]string{
+		`{"Action":"output","Package":"p","Test":"TestOK","Output":"dial tcp: lookup example.com: no such host\n"}`,
+		`{"Action":"pass","Package":"p","Test":"TestOK"}`,
+		`{"Action":"output","Package":"p","Test":"TestBad","Output":"want 1, got 2\n"}`,
+		`{"Action":"fail","Package":"p","Test":"TestBad"}`,
+		`{"Action":"output","Package":"p","Output":"FAIL\tp\t0.01s\n"}`,
+		`{"Action":"fail","Package":"p"}`,
+		`{"Action":"output","Package":"q","Output":"panic: in passing package\n"}`,
+		`{"Action":"pass","Package":"q"}`,
+		`not json`,
+	}, "\n")
+
+	var all, failed bytes.Buffer
+	packages, tests := ParseTestEvents(strings.NewReader(stream), &all, &failed)
+	if len(packages) != 2 || len(tests) != 2 {
+		t.Fatalf("got %d packages and %d tests, want 2 and 2", len(packages), len(tests))
+	}
+	if got, want := failed.String(), "want 1, got 2\nFAIL\tp\t0.01s\n"; got != want {
+		t.Errorf("failed output = %q, want %q", got, want)
+	}
+	if !strings.Contains(all.String(), "no such host") {
+		t.Errorf("output of passing tests missing from the full output: %q", all.String())
+	}
+}
@@ -0,0 +1,207 @@
+package internal
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Upstream is a bare clone of the branches and tags of the repo under test,
+// kept in the workspace. Refs are resolved against it once per run, and
+// every module is then tested against checkouts of exactly those commits.
+type Upstream struct {
+	Repo string
+	Dir  string // bare clone
+	ws   string
+}
+
+// upstreamRefspec is what the upstream clone fetches when it has to fetch
+// more than a single ref: branches only, plus the tags pointing into them,
+// and not e.g. GitHub's refs/pull/*.
+const upstreamRefspec = "+refs/heads/*:refs/heads/*"
+
+// PrepareUpstream clones repo into a bare repo under ws/upstream, or reuses
+// the existing one. When gitMirror is set, repo is fetched from its mirror
+// there instead of its remote. Nothing is fetched into an existing clone
+// here; Resolve fetches the refs a run needs.
+func PrepareUpstream(ws, repo, gitMirror string) (*Upstream, error) {
+	name := strings.ReplaceAll(repoModulePath(repo), "/", "_") + ".git"
+	u := &Upstream{
+		Repo: repo,
+		Dir:  filepath.Join(ws, "upstream", name),
+		ws:   ws,
+	}
+
+	url := mirrorURL(gitMirror, repoCloneURL(repo))
+	if _, err := os.Stat(u.Dir); err == nil {
+		// Follow --git-mirror being turned on or off between runs, and
+		// narrow clones made with --mirror by earlier versions
+		u.git("config", "--unset", "remote.origin.mirror")
+		if err := u.git("remote", "set-url", "origin", url); err != nil {
+			return nil, fmt.Errorf("failed to update upstream clone: %w", err)
+		}
+		if err := u.git("config", "remote.origin.fetch", upstreamRefspec); err != nil {
+			return nil, fmt.Errorf("failed to update upstream clone: %w", err)
+		}
+		return u, nil
+	}
+
+	fmt.Printf("📦 Cloning upstream repo %s\n", Redact(url))
+	if err := os.MkdirAll(filepath.Dir(u.Dir), 0755); err != nil {
+		return nil, err
+	}
+	clone := exec.Command("git", "clone", "--bare", "--quiet", url, u.Dir)
+	clone.Stdout = os.Stdout
+	clone.Stderr = os.Stderr
+	if err := clone.Run(); err != nil {
+		os.RemoveAll(u.Dir)
+		return nil, fmt.Errorf("failed to clone %s: %w", Redact(url), err)
+	}
+	if err := u.git("config", "remote.origin.fetch", upstreamRefspec); err != nil {
+		os.RemoveAll(u.Dir)
+		return nil, fmt.Errorf("failed to configure upstream clone: %w", err)
+	}
+	return u, nil
+}
+
+// Resolve returns the commit SHA of ref. Commits the clone already has are
+// used as they are. Other refs are fetched on their own, so branches are
+// current and refs outside the clone's branches (e.g. a commit only
+// reachable from a PR ref) are found. Expressions like main~2 can't be
+// fetched, so they are resolved after fetching every branch.
+func (u *Upstream) Resolve(ref string) (string, error) {
+	if isCommitSHA(ref) {
+		if sha, err := u.revParse(ref); err == nil {
+			return sha, nil
+		}
+	}
+	if err := u.gitQuiet("fetch", "--quiet", "origin", ref); err == nil {
+		sha, err := u.revParse("FETCH_HEAD")
+		if err != nil {
+			return "", err
+		}
+		// Keep the commit reachable, so gc never removes it from under the
+		// checkouts that borrow its objects
+		if err := u.gitQuiet("update-ref", "refs/grater/"+sha, sha); err != nil {
+			return "", fmt.Errorf("failed to keep %s: %w", sha, err)
+		}
+		return sha, nil
+	}
+	if err := u.git("fetch", "--quiet", "origin"); err != nil {
+		return "", fmt.Errorf("failed to fetch %s: %w", Redact(u.Repo), err)
+	}
+	sha, err := u.revParse(ref)
+	if err != nil {
+		return "", fmt.Errorf("ref %s does not exist in %s", ref, Redact(u.Repo))
+	}
+	return sha, nil
+}
+
+// isCommitSHA reports whether ref is a full commit SHA.
+func isCommitSHA(ref string) bool {
+	if len(ref) != 40 && len(ref) != 64 {
+		return false
+	}
+	return strings.Trim(ref, "0123456789abcdef") == ""
+}
+
+// Checkout returns a directory holding the tree of commit sha, creating it
+// under ws/upstream/checkouts if needed.
+func (u *Upstream) Checkout(sha string) (string, error) {
+	dir := filepath.Join(u.ws, "upstream", "checkouts", sha)
+	if _, err := os.Stat(dir); err == nil {
+		// The mtime marks when a checkout was last used, for PruneCheckouts
+		now := time.Now()
+		os.Chtimes(dir, now, now)
+		return dir, nil
+	}
+
+	// Check out into a temp dir first so a half-written checkout is never reused
+	tmp := dir + ".tmp"
+	os.RemoveAll(tmp)
+	if err := exec.Command("git", "clone", "--quiet", "--shared", "--no-checkout", u.Dir, tmp).Run(); err != nil {
+		return "", fmt.Errorf("failed to create checkout of %s: %w", sha, err)
+	}
+	if out, err := exec.Command("git", "-C", tmp, "checkout", "--quiet", "--detach", sha).CombinedOutput(); err != nil {
+		os.RemoveAll(tmp)
+		return "", fmt.Errorf("failed to check out %s: %v: %s", sha, err, strings.TrimSpace(string(out)))
+	}
+	if err := os.Rename(tmp, dir); err != nil {
+		return "", err
+	}
+	return dir, nil
+}
+
+// PruneCheckouts removes the upstream checkouts under ws that weren't used
+// for unusedFor, including any left half-written, and returns how many it
+// removed. Zero removes every checkout.
+func PruneCheckouts(ws string, unusedFor time.Duration) (int, error) {
+	dir := filepath.Join(ws, "upstream", "checkouts")
+	entries, err := os.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	removed := 0
+	for _, e := range entries {
+		info, err := e.Info()
+		if err != nil {
+			continue
+		}
+		if unusedFor > 0 && time.Since(info.ModTime()) < unusedFor {
+			continue
+		}
+		if err := os.RemoveAll(filepath.Join(dir, e.Name())); err != nil {
+			return removed, fmt.Errorf("failed to remove checkout %s: %w", e.Name(), err)
+		}
+		removed++
+	}
+	return removed, nil
+}
+
+// Commits lists the commits after good up to and including bad, oldest
+// first. Only first parents are followed, so a merged branch counts as the
+// single merge commit and the list is linear.
+func (u *Upstream) Commits(good, bad string) ([]string, error) {
+	out, err := exec.Command("git", "--git-dir", u.Dir, "rev-list", "--first-parent", "--reverse", good+".."+bad).Output()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list commits %s..%s: %w", good, bad, err)
+	}
+	return strings.Fields(string(out)), nil
+}
+
+// CommitInfo returns the subject and author of commit sha.
+func (u *Upstream) CommitInfo(sha string) (subject, author string, err error) {
+	out, err := exec.Command("git", "--git-dir", u.Dir, "log", "-1", "--format=%s%x00%an <%ae>", sha).Output()
+	if err != nil {
+		return "", "", fmt.Errorf("failed to read commit %s: %w", sha, err)
+	}
+	subject, author, _ = strings.Cut(strings.TrimSpace(string(out)), "\x00")
+	return subject, author, nil
+}
+
+func (u *Upstream) revParse(ref string) (string, error) {
+	out, err := exec.Command("git", "--git-dir", u.Dir, "rev-parse", "--verify", "--quiet", ref+"^{commit}").Output()
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+func (u *Upstream) git(args ...string) error {
+	cmd := exec.Command("git", append([]string{"--git-dir", u.Dir}, args...)...)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	return cmd.Run()
+}
+
+// gitQuiet is like git but drops the output, for commands whose failure the
+// caller handles.
+func (u *Upstream) gitQuiet(args ...string) error {
+	return exec.Command("git", append([]string{"--git-dir", u.Dir}, args...)...).Run()
+}
@@ -0,0 +1,100 @@
+package internal
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPruneCheckouts(t *testing.T) {
+	ws := t.TempDir()
+	dir := filepath.Join(ws, "upstream", "checkouts")
+	old := time.Now().Add(-30 * 24 * time.Hour)
+	for name, used := range map[string]time.Time{"aaa": old, "bbb": time.Now(), "ccc.tmp": old} {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Join(path, "sub"), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(path, used, used); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	left := func() []string {
+		entries, _ := os.ReadDir(dir)
+		var names []string
+		for _, e := range entries {
+			names = append(names, e.Name())
+		}
+		return names
+	}
+	if removed, err := PruneCheckouts(ws, 7*24*time.Hour); err != nil || removed != 2 {
+		t.Fatalf("PruneCheckouts = %d, %v; want 2, nil", removed, err)
+	}
+	if got := left(); !slices.Equal(got, []string{"bbb"}) {
+		t.Errorf("checkouts left = %v, want the recently used [bbb]", got)
+	}
+	if removed, err := PruneCheckouts(ws, 0); err != nil || removed != 1 {
+		t.Fatalf("PruneCheckouts(0) = %d, %v; want 1, nil", removed, err)
+	}
+	if removed, err := PruneCheckouts(t.TempDir(), 0); err != nil || removed != 0 {
+		t.Errorf("pruning a workspace without checkouts = %d, %v; want 0, nil", removed, err)
+	}
+}
+
+func TestUpstreamFetchesOnlyNeededRefs(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not found")
+	}
+	git := func(dir string, args ...string) string {
+		t.Helper()
+		cmd := exec.Command("git", append([]string{"-c", "user.name=test", "-c", "user.email=test@example.com"}, args...)...)
+		cmd.Dir = dir
+		out, err := cmd.CombinedOutput()
+		if err != nil {
+			t.Fatalf("git %s: %v\n%s", strings.Join(args, " "), err, out)
+		}
+		return strings.TrimSpace(string(out))
+	}
+
+	remote := t.TempDir()
+	gitRepo(t, remote, map[string]string{"go.mod": "module example.com/up\n"})
+	git(remote, "branch", "-M", "main")
+	first := git(remote, "rev-parse", "HEAD")
+	git(remote, "commit", "--quiet", "--allow-empty", "-m", "pr")
+	pr := git(remote, "rev-parse", "HEAD")
+	git(remote, "update-ref", "refs/pull/1/head", pr)
+	git(remote, "reset", "--quiet", "--hard", first)
+
+	ws := t.TempDir()
+	u, err := PrepareUpstream(ws, "file://"+remote, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if refs := git(ws, "--git-dir", u.Dir, "for-each-ref", "--format=%(refname)"); strings.Contains(refs, "refs/pull/") {
+		t.Errorf("clone fetched pull request refs:\n%s", refs)
+	}
+
+	// A branch that moved is fetched again, not read from the clone
+	git(remote, "commit", "--quiet", "--allow-empty", "-m", "second")
+	second := git(remote, "rev-parse", "HEAD")
+	if u, err = PrepareUpstream(ws, "file://"+remote, ""); err != nil {
+		t.Fatal(err)
+	}
+	for ref, want := range map[string]string{"main": second, "main~1": first, "refs/pull/1/head": pr, pr: pr} {
+		sha, err := u.Resolve(ref)
+		if err != nil {
+			t.Fatalf("Resolve(%s): %v", ref, err)
+		}
+		if sha != want {
+			t.Errorf("Resolve(%s) = %s, want %s", ref, sha, want)
+		}
+	}
+	if _, err := u.Checkout(pr); err != nil {
+		t.Errorf("checkout of a commit only on a pull request ref: %v", err)
+	}
+}
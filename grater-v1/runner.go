@@ -0,0 +1,12 @@
+// Package graterbasics embeds the build context of the runner image, so
+// grater can build it from any directory and the agent in the image always
+// matches the grater binary.
+package graterbasics
+
+import "embed"
+
+// RunnerSource holds the runner image's Dockerfile and the sources of
+// grater-agent.
+//
+//go:embed go.mod go.sum docker/dockerfile cmd/grater-agent internal
+var RunnerSource embed.FS